@@ -8,14 +8,15 @@ import (
 	"net/http"
 )
 
+var upgrader = websock.Upgrader{Compression: &websock.CompressionOptions{}}
+
 // WebSocketHandler handles WebSocket connections and implements echo functionality
 func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("Received request headers:", r.Header)
 
-	ws, err := websock.NewWebSocketWithUpgrade(w, r)
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
-		http.Error(w, "WebSocket upgrade failed", http.StatusBadRequest)
 		return
 	}
 
@@ -39,10 +39,10 @@ func FragmentedFrames(data []byte, maxFrameSize int, opcode Opcode, isServer boo
 	totalLength := len(data)
 	if totalLength == 0 {
 		if isServer {
-			frame, err := NewClientFrame(true, opcode, []byte{})
+			frame, err := NewServerFrame(true, opcode, []byte{})
 			return []*Frame{frame}, err
 		} else {
-			frame, err := NewServerFrame(true, opcode, []byte{})
+			frame, err := NewClientFrame(true, opcode, []byte{})
 			return []*Frame{frame}, err
 		}
 	}
@@ -68,9 +68,9 @@ func FragmentedFrames(data []byte, maxFrameSize int, opcode Opcode, isServer boo
 
 		var err error
 		if isServer {
-			frames[i], err = NewClientFrame(isFinal, frameOpcode, chunk)
-		} else {
 			frames[i], err = NewServerFrame(isFinal, frameOpcode, chunk)
+		} else {
+			frames[i], err = NewClientFrame(isFinal, frameOpcode, chunk)
 		}
 
 		if err != nil {
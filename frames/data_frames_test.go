@@ -0,0 +1,48 @@
+package frames
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFragmentedFramesMasking(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		isServer bool
+	}{
+		{name: "server", data: []byte("hello fragmented world"), isServer: true},
+		{name: "client", data: []byte("hello fragmented world"), isServer: false},
+		{name: "server empty", data: []byte{}, isServer: true},
+		{name: "client empty", data: []byte{}, isServer: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, err := FragmentedFrames(bytes.Clone(tt.data), 5, OpBinary, tt.isServer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var payload []byte
+			for i, frame := range frames {
+				if frame.Masked == tt.isServer {
+					t.Errorf("frame %d: Masked = %v, want %v", i, frame.Masked, !tt.isServer)
+				}
+				wantOpcode := OpContinuation
+				if i == 0 {
+					wantOpcode = OpBinary
+				}
+				if frame.OpCode != wantOpcode {
+					t.Errorf("frame %d: OpCode = %v, want %v", i, frame.OpCode, wantOpcode)
+				}
+				if frame.Fin != (i == len(frames)-1) {
+					t.Errorf("frame %d: Fin = %v", i, frame.Fin)
+				}
+				frame.UnmaskPayload()
+				payload = append(payload, frame.PayloadData...)
+			}
+			if !bytes.Equal(payload, tt.data) {
+				t.Errorf("payload = %q, want %q", payload, tt.data)
+			}
+		})
+	}
+}
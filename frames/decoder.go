@@ -0,0 +1,144 @@
+package frames
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// minPayloadChunk is the size of the first read of a payload which does not fit the buffer passed to ReadPayload.
+const minPayloadChunk int = 64 << 10
+
+// maxHeaderSize is the size of the largest frame header: 2 bytes, an 8 byte extended length and a 4 byte masking key.
+const maxHeaderSize int = minimalHeaderSize + uint64ByteSize + maskKeySize
+
+// Decoder decodes frames from a reader, reusing its buffers between frames. Frames are decoded into a Frame supplied by
+// the caller, so a steady stream of frames is decoded without allocations. A Decoder is not safe for concurrent use.
+type Decoder struct {
+	r       io.Reader
+	header  [maxHeaderSize]byte
+	payload []byte
+}
+
+// NewDecoder returns a Decoder which reads frames from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Reset makes the Decoder read frames from r, keeping its payload buffer.
+func (d *Decoder) Reset(r io.Reader) {
+	d.r = r
+}
+
+// Decode reads the next frame into f. The payload is read into a buffer owned by the Decoder, so f.PayloadData is only
+// valid until the next call of Decode.
+func (d *Decoder) Decode(f *Frame) error {
+	if err := d.DecodeHeader(f); err != nil {
+		return err
+	}
+	if err := d.ReadPayload(f, d.payload[:0]); err != nil {
+		return err
+	}
+	d.payload = f.PayloadData[:0]
+	return nil
+}
+
+// DecodeHeader reads the header of the next frame into f, leaving the payload unread, so its Frame.PayloadLength can
+// be checked before it is read with ReadPayload. f.PayloadData is set to nil.
+func (d *Decoder) DecodeHeader(f *Frame) error {
+	if _, err := io.ReadFull(d.r, d.header[:minimalHeaderSize]); err != nil {
+		return err
+	}
+	size := headerSize(d.header[secondHeaderByte])
+	if _, err := io.ReadFull(d.r, d.header[minimalHeaderSize:size]); err != nil {
+		return err
+	}
+	return parseHeader(d.header[:size], f)
+}
+
+// headerSize returns the size of a frame header from its second byte, which holds the mask bit and the payload length
+// indicator.
+func headerSize(second byte) int {
+	size := minimalHeaderSize
+	switch second & 0b01111111 {
+	case PayloadLen16BitCode:
+		size += uint16byteSize
+	case PayloadLen64BitCode:
+		size += uint64ByteSize
+	}
+	if second&maskPayloadMasked != 0 {
+		size += maskKeySize
+	}
+	return size
+}
+
+// parseHeader decodes a complete frame header of headerSize bytes into f. f.PayloadData is set to nil.
+func parseHeader(header []byte, f *Frame) error {
+	*f = Frame{
+		Fin:    header[firsHeaderByte]&maskFIN != 0,
+		Rsv1:   header[firsHeaderByte]&maskRSV1 != 0,
+		Rsv2:   header[firsHeaderByte]&maskRSV2 != 0,
+		Rsv3:   header[firsHeaderByte]&maskRSV3 != 0,
+		OpCode: Opcode(header[firsHeaderByte] & maskOPCODE),
+		Masked: header[secondHeaderByte]&maskPayloadMasked != 0,
+	}
+	rest := header[minimalHeaderSize:]
+	switch payloadLenIndicator := header[secondHeaderByte] & 0b01111111; payloadLenIndicator {
+	case PayloadLen16BitCode:
+		f.PayloadLength = uint64(binary.BigEndian.Uint16(rest))
+		rest = rest[uint16byteSize:]
+	case PayloadLen64BitCode:
+		f.PayloadLength = binary.BigEndian.Uint64(rest)
+		rest = rest[uint64ByteSize:]
+		if f.PayloadLength&(1<<63) != 0 {
+			return errors.New("most significant bit of 64-bit length must be 0")
+		}
+	default:
+		f.PayloadLength = uint64(payloadLenIndicator)
+	}
+	if f.Masked {
+		copy(f.MaskingKey[:], rest)
+	}
+	return nil
+}
+
+// ReadPayload reads and unmasks the payload of a frame whose header was read by DecodeHeader into buf, which is grown
+// if its capacity is too small, and sets f.PayloadData to the payload. buf is grown as the payload arrives rather than
+// up front, so a payload length announced by the header is only allocated once that much payload was received.
+func (d *Decoder) ReadPayload(f *Frame, buf []byte) error {
+	if f.PayloadLength > math.MaxInt {
+		return fmt.Errorf("payload length %v exceeds the maximum allocation size", f.PayloadLength)
+	}
+	n := int(f.PayloadLength)
+	if cap(buf) >= n {
+		f.PayloadData = buf[:n]
+		if _, err := io.ReadFull(d.r, f.PayloadData); err != nil {
+			return err
+		}
+	} else if err := d.readGrowing(f, buf[:0], n); err != nil {
+		return err
+	}
+	if f.Masked {
+		f.UnmaskPayload()
+	}
+	return nil
+}
+
+// readGrowing reads a payload of n bytes into buf, at most doubling its size for every read, and sets f.PayloadData
+// to the payload.
+func (d *Decoder) readGrowing(f *Frame, buf []byte, n int) error {
+	for len(buf) < n {
+		chunk := min(n-len(buf), max(len(buf), minPayloadChunk))
+		buf = slices.Grow(buf, chunk)
+		read, err := io.ReadFull(d.r, buf[len(buf):len(buf)+chunk])
+		buf = buf[:len(buf)+read]
+		if err != nil {
+			return err
+		}
+	}
+	f.PayloadData = buf
+	return nil
+}
@@ -0,0 +1,120 @@
+package frames
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+)
+
+// loopReader reads data over and over again.
+type loopReader struct {
+	data []byte
+	pos  int
+}
+
+func (l *loopReader) Read(p []byte) (int, error) {
+	if l.pos == len(l.data) {
+		l.pos = 0
+	}
+	n := copy(p, l.data[l.pos:])
+	l.pos += n
+	return n, nil
+}
+
+// encodedFrame returns a final binary frame with n bytes of payload in its wire format.
+func encodedFrame(t testing.TB, n int, masked bool) []byte {
+	t.Helper()
+	frame, err := NewFrame(true, OpBinary, bytes.Repeat([]byte{7}, n), masked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := frame.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestDecoder(t *testing.T) {
+	var stream []byte
+	sizes := []int{0, 125, 126, 65535, 65536, 200000}
+	for i, n := range sizes {
+		stream = append(stream, encodedFrame(t, n, i%2 == 0)...)
+	}
+	d := NewDecoder(bytes.NewReader(stream))
+	var f Frame
+	for _, n := range sizes {
+		if err := d.Decode(&f); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if f.PayloadLength != uint64(n) || !bytes.Equal(f.PayloadData, bytes.Repeat([]byte{7}, n)) {
+			t.Errorf("Decode() = %d bytes of payload, want %d", len(f.PayloadData), n)
+		}
+	}
+	if err := d.Decode(&f); !errors.Is(err, io.EOF) {
+		t.Errorf("Decode() at the end error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestDecoderAllocations(t *testing.T) {
+	for _, masked := range []bool{false, true} {
+		d := NewDecoder(&loopReader{data: encodedFrame(t, 1000, masked)})
+		var f Frame
+		allocs := testing.AllocsPerRun(100, func() {
+			if err := d.Decode(&f); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("Decode() of masked %v frames allocates %v times, want 0", masked, allocs)
+		}
+	}
+}
+
+func TestDecoderReadPayload(t *testing.T) {
+	encoded := encodedFrame(t, 1000, true)
+	d := NewDecoder(&loopReader{data: encoded})
+	var f Frame
+	if err := d.DecodeHeader(&f); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10, 1000)
+	if err := d.ReadPayload(&f, buf); err != nil {
+		t.Fatal(err)
+	}
+	if &f.PayloadData[0] != &buf[0] {
+		t.Error("ReadPayload() did not reuse a buffer with enough capacity")
+	}
+	if !bytes.Equal(f.PayloadData, bytes.Repeat([]byte{7}, 1000)) {
+		t.Error("ReadPayload() did not unmask the payload")
+	}
+
+	if err := d.DecodeHeader(&f); err != nil {
+		t.Fatal(err)
+	}
+	small := make([]byte, 0, 10)
+	if err := d.ReadPayload(&f, small); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.PayloadData) != 1000 || !bytes.Equal(f.PayloadData, bytes.Repeat([]byte{7}, 1000)) {
+		t.Errorf("ReadPayload() into a small buffer = %d bytes, want the payload", len(f.PayloadData))
+	}
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	for _, n := range []int{10, 1000, 70000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			d := NewDecoder(&loopReader{data: encodedFrame(b, n, true)})
+			var f Frame
+			b.ReportAllocs()
+			b.SetBytes(int64(n))
+			for b.Loop() {
+				if err := d.Decode(&f); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -210,10 +210,30 @@ func (f *Frame) MaskPayload() {
 	if !f.Masked || len(f.PayloadData) == 0 {
 		return
 	}
+	MaskBytes(f.MaskingKey, 0, f.PayloadData)
+}
 
-	for i := 0; i < len(f.PayloadData); i++ {
-		f.PayloadData[i] ^= f.MaskingKey[i%4]
+// MaskBytes masks or unmasks b with key, starting at position pos of the key, so a payload can be masked in chunks. It
+// returns the position of the key following b. Eight bytes are masked per step with the key rotated to pos.
+func MaskBytes(key [4]byte, pos int, b []byte) int {
+	pos &= 3
+	if len(b) >= 8 {
+		var rotated [4]byte
+		for i := range rotated {
+			rotated[i] = key[(pos+i)&3]
+		}
+		key32 := uint64(binary.LittleEndian.Uint32(rotated[:]))
+		key64 := key32 | key32<<32
+		for len(b) >= 8 {
+			binary.LittleEndian.PutUint64(b, binary.LittleEndian.Uint64(b)^key64)
+			b = b[8:]
+		}
 	}
+	for i := range b {
+		b[i] ^= key[pos]
+		pos = (pos + 1) & 3
+	}
+	return pos
 }
 
 // UnmaskPayload removes masking from the payload data
@@ -223,131 +243,90 @@ func (f *Frame) UnmaskPayload() {
 
 // MarshalBinary serializes a frame to its wire format. It fulfils encoding.BinaryMarshaler interface.
 func (f *Frame) MarshalBinary() ([]byte, error) {
-	headerSize := minimalHeaderSize
+	buf := f.AppendHeader(make([]byte, 0, f.HeaderSize()+len(f.PayloadData)))
+	return append(buf, f.PayloadData...), nil
+}
 
-	if f.PayloadLength <= PayloadLen125OrLess {
-	} else if f.PayloadLength <= math.MaxUint16 {
-		headerSize += uint16byteSize
-	} else {
+// HeaderSize returns the size of the wire format of the frame header.
+func (f *Frame) HeaderSize() int {
+	headerSize := minimalHeaderSize
+	if f.PayloadLength > math.MaxUint16 {
 		headerSize += uint64ByteSize
+	} else if f.PayloadLength > PayloadLen125OrLess {
+		headerSize += uint16byteSize
 	}
-
 	if f.Masked {
 		headerSize += maskKeySize
 	}
+	return headerSize
+}
 
-	buf := make([]byte, headerSize+len(f.PayloadData))
-
-	buf[firsHeaderByte] = byte(f.OpCode) & maskOPCODE
+// AppendHeader appends the wire format of the frame header to dst and returns the extended slice. The payload is not
+// appended, so it can be written after the header without copying it.
+func (f *Frame) AppendHeader(dst []byte) []byte {
+	first := byte(f.OpCode) & maskOPCODE
 	if f.Fin {
-		buf[firsHeaderByte] |= maskFIN
+		first |= maskFIN
 	}
 	if f.Rsv1 {
-		buf[firsHeaderByte] |= maskRSV1
+		first |= maskRSV1
 	}
 	if f.Rsv2 {
-		buf[firsHeaderByte] |= maskRSV2
+		first |= maskRSV2
 	}
 	if f.Rsv3 {
-		buf[firsHeaderByte] |= maskRSV3
+		first |= maskRSV3
 	}
 
+	var second byte
 	if f.Masked {
-		buf[secondHeaderByte] |= maskPayloadMasked
+		second |= maskPayloadMasked
 	}
 
 	if f.PayloadLength <= PayloadLen125OrLess {
-		buf[secondHeaderByte] |= byte(f.PayloadLength)
+		dst = append(dst, first, second|byte(f.PayloadLength))
 	} else if f.PayloadLength <= math.MaxUint16 {
-		buf[secondHeaderByte] |= PayloadLen16BitCode
-		binary.BigEndian.PutUint16(buf[2:4], uint16(f.PayloadLength))
+		dst = append(dst, first, second|PayloadLen16BitCode)
+		dst = binary.BigEndian.AppendUint16(dst, uint16(f.PayloadLength))
 	} else {
-		buf[secondHeaderByte] |= PayloadLen64BitCode
-		binary.BigEndian.PutUint64(buf[2:10], f.PayloadLength)
+		dst = append(dst, first, second|PayloadLen64BitCode)
+		dst = binary.BigEndian.AppendUint64(dst, f.PayloadLength)
 	}
 
 	if f.Masked {
-		var maskPos int
-		if f.PayloadLength <= PayloadLen125OrLess {
-			maskPos = 2
-		} else if f.PayloadLength <= math.MaxUint16 {
-			maskPos = 4
-		} else {
-			maskPos = 10
-		}
-
-		copy(buf[maskPos:maskPos+4], f.MaskingKey[:])
+		dst = append(dst, f.MaskingKey[:]...)
 	}
-	payloadPos := headerSize
-	copy(buf[payloadPos:], f.PayloadData)
-
-	return buf, nil
+	return dst
 }
 
 // DecodeFrame deserializes a frame from its wire format
 func DecodeFrame(r io.Reader) (*Frame, error) {
-	header := make([]byte, minimalHeaderSize)
-
-	if n, err := io.ReadFull(r, header); err != nil || n != minimalHeaderSize {
-		switch err {
-		case nil:
-			return nil, fmt.Errorf("invalid header size %v expected %v", n, minimalHeaderSize)
-		default:
-			return nil, err
-		}
+	frame, err := DecodeFrameHeader(r)
+	if err != nil {
+		return nil, err
 	}
-
-	frame := &Frame{}
-
-	frame.Fin = (header[firsHeaderByte] & maskFIN) != 0
-	frame.Rsv1 = (header[firsHeaderByte] & maskRSV1) != 0
-	frame.Rsv2 = (header[firsHeaderByte] & maskRSV2) != 0
-	frame.Rsv3 = (header[firsHeaderByte] & maskRSV3) != 0
-	frame.OpCode = Opcode(header[firsHeaderByte] & maskOPCODE)
-
-	frame.Masked = (header[secondHeaderByte] & maskPayloadMasked) != 0
-	payloadLenIndicator := header[secondHeaderByte] & 0b01111111
-
-	switch {
-	case payloadLenIndicator <= byte(PayloadLen125OrLess):
-		frame.PayloadLength = uint64(payloadLenIndicator)
-	case payloadLenIndicator == PayloadLen16BitCode:
-		extendedLen := make([]byte, uint16byteSize)
-		if _, err := io.ReadFull(r, extendedLen); err != nil {
-			return nil, err
-		}
-		frame.PayloadLength = uint64(binary.BigEndian.Uint16(extendedLen))
-	case payloadLenIndicator == PayloadLen64BitCode:
-		extendedLen := make([]byte, uint64ByteSize)
-		if _, err := io.ReadFull(r, extendedLen); err != nil {
-			return nil, err
-		}
-		frame.PayloadLength = binary.BigEndian.Uint64(extendedLen)
-		if frame.PayloadLength&(1<<63) != 0 {
-			return nil, errors.New("most significant bit of 64-bit length must be 0")
-		}
+	if err = ReadFramePayload(r, frame); err != nil {
+		return nil, err
 	}
+	return frame, nil
+}
 
-	if frame.Masked {
-		maskingKey := make([]byte, maskKeySize)
-		if _, err := io.ReadFull(r, maskingKey); err != nil {
-			return nil, err
-		}
-		copy(frame.MaskingKey[:], maskingKey)
+// DecodeFrameHeader deserializes the header of a frame from its wire format. The payload is left unread, so its
+// Frame.PayloadLength can be checked before ReadFramePayload allocates it.
+func DecodeFrameHeader(r io.Reader) (*Frame, error) {
+	frame := &Frame{}
+	if err := NewDecoder(r).DecodeHeader(frame); err != nil {
+		return nil, err
 	}
+	return frame, nil
+}
 
-	if frame.PayloadLength > 0 {
-		frame.PayloadData = make([]byte, frame.PayloadLength)
-		if _, err := io.ReadFull(r, frame.PayloadData); err != nil {
-			return nil, err
-		}
-
-		if frame.Masked {
-			frame.UnmaskPayload()
-		}
+// ReadFramePayload reads and unmasks the payload of a frame whose header was read by DecodeFrameHeader
+func ReadFramePayload(r io.Reader, frame *Frame) error {
+	if frame.PayloadLength == 0 {
+		return nil
 	}
-
-	return frame, nil
+	return NewDecoder(r).ReadPayload(frame, nil)
 }
 
 // IsControl returns true if the frame is a control frame
@@ -0,0 +1,116 @@
+package frames
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"testing"
+)
+
+// maskBytesReference masks b one byte at a time, the way RFC 6455 section 5.3 describes it.
+func maskBytesReference(key [4]byte, pos int, b []byte) int {
+	for i := range b {
+		b[i] ^= key[(pos+i)%4]
+	}
+	return (pos + len(b)) % 4
+}
+
+func TestMaskBytes(t *testing.T) {
+	key := [4]byte{0x12, 0x34, 0x56, 0x78}
+	data := make([]byte, 67)
+	for i := range data {
+		data[i] = byte(i * 31)
+	}
+	// Every length around the eight byte steps, starting at every position of the key, including unreduced ones.
+	for n := range len(data) + 1 {
+		for pos := range 8 {
+			want := bytes.Clone(data[:n])
+			wantPos := maskBytesReference(key, pos, want)
+			got := bytes.Clone(data[:n])
+			if gotPos := MaskBytes(key, pos, got); !bytes.Equal(got, want) || gotPos != wantPos {
+				t.Fatalf("MaskBytes(pos %d, %d bytes) = %x, %d, want %x, %d", pos, n, got, gotPos, want, wantPos)
+			}
+		}
+	}
+}
+
+func TestMaskBytesChunked(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 2000 {
+		data := make([]byte, rng.IntN(300))
+		for i := range data {
+			data[i] = byte(rng.Uint32())
+		}
+		var key [4]byte
+		for i := range key {
+			key[i] = byte(rng.Uint32())
+		}
+		pos := rng.IntN(4)
+		want := bytes.Clone(data)
+		wantPos := maskBytesReference(key, pos, want)
+
+		got := bytes.Clone(data)
+		gotPos := pos
+		for rest := got; len(rest) > 0; {
+			n := rng.IntN(len(rest) + 1)
+			gotPos = MaskBytes(key, gotPos, rest[:n])
+			rest = rest[n:]
+		}
+		if !bytes.Equal(got, want) || gotPos != wantPos {
+			t.Fatalf("MaskBytes() in chunks of %d bytes from pos %d differs from the reference", len(data), pos)
+		}
+	}
+}
+
+func TestMaskPayload(t *testing.T) {
+	payload := []byte("mask this payload, then unmask it again")
+	f := &Frame{Masked: true, MaskingKey: [4]byte{1, 2, 3, 4}, PayloadData: bytes.Clone(payload)}
+	f.MaskPayload()
+	want := bytes.Clone(payload)
+	maskBytesReference(f.MaskingKey, 0, want)
+	if !bytes.Equal(f.PayloadData, want) {
+		t.Fatalf("MaskPayload() = %x, want %x", f.PayloadData, want)
+	}
+	f.UnmaskPayload()
+	if !bytes.Equal(f.PayloadData, payload) {
+		t.Errorf("UnmaskPayload() = %q, want %q", f.PayloadData, payload)
+	}
+}
+
+func BenchmarkMaskBytes(b *testing.B) {
+	buf := make([]byte, 64<<10)
+	key := [4]byte{1, 2, 3, 4}
+	b.SetBytes(int64(len(buf)))
+	for b.Loop() {
+		MaskBytes(key, 1, buf)
+	}
+}
+
+func TestDecodeFrameTruncatedPayload(t *testing.T) {
+	header := (&Frame{Fin: true, OpCode: OpBinary, PayloadLength: 1 << 40}).AppendHeader(nil)
+	encoded := append(header, bytes.Repeat([]byte{'x'}, 1000)...)
+	// The announced terabyte must not be allocated before the payload arrives.
+	if _, err := DecodeFrame(bytes.NewReader(encoded)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("DecodeFrame() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDecodeFrameLargePayload(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 50000)
+	frame, err := NewClientFrame(true, OpBinary, bytes.Clone(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := frame.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeFrame(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.PayloadData, payload) {
+		t.Errorf("DecodeFrame() payload differs from the encoded one")
+	}
+}
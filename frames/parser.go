@@ -0,0 +1,136 @@
+package frames
+
+// defaultStreamThreshold is the payload size above which a Parser streams frames when StreamThreshold is not set.
+const defaultStreamThreshold int = 64 << 10
+
+// ParseEvent is the result of feeding bytes to a Parser.
+type ParseEvent int
+
+const (
+	// NeedMore means that the bytes were consumed without completing a frame or a payload chunk.
+	NeedMore ParseEvent = iota
+	// FrameParsed means that a frame was parsed completely. Parser.Frame returns it with its unmasked payload.
+	FrameParsed
+	// HeaderParsed means that the header of a frame with a payload above the stream threshold was parsed. Parser.Frame
+	// returns it without payload, and the payload follows in PayloadChunk events.
+	HeaderParsed
+	// PayloadChunk means that a chunk of the payload of a streamed frame was parsed. The PayloadData of Parser.Frame is
+	// the unmasked chunk, and Parser.Remaining returns the number of payload bytes which still follow.
+	PayloadChunk
+)
+
+// Parser is an incremental frame parser for bytes which arrive in arbitrary chunks, such as in an event loop which can
+// not block on an io.Reader. Bytes are fed with Feed, which reports how many of them were consumed and whether they
+// completed a frame or a chunk of a streamed payload. The zero value is ready to use. A Parser is not safe for
+// concurrent use.
+type Parser struct {
+	// StreamThreshold is the largest payload of a frame which is buffered and emitted with the complete frame. Larger
+	// payloads are emitted in chunks as their bytes arrive. Zero means 64 KiB.
+	StreamThreshold int
+
+	header    [maxHeaderSize]byte
+	headerLen int
+	frame     Frame
+	inPayload bool
+	streaming bool
+	remaining uint64
+	pos       int
+	payload   []byte
+	err       error
+}
+
+// Feed parses the bytes in b and returns the number of bytes consumed and the event they completed. It stops after the
+// first event, so it is called again with the bytes which were not consumed. NeedMore is returned once all of b was
+// consumed without completing an event. Payload chunks of streamed frames alias b and are unmasked in place, while the
+// payload of complete frames is buffered by the Parser. Both are only valid until the next call of Feed. An error is
+// returned for every call once the bytes are not a valid frame.
+func (p *Parser) Feed(b []byte) (int, ParseEvent, error) {
+	if p.err != nil {
+		return 0, NeedMore, p.err
+	}
+	n := 0
+	if !p.inPayload {
+		for p.headerLen < minimalHeaderSize && n < len(b) {
+			p.header[p.headerLen] = b[n]
+			p.headerLen++
+			n++
+		}
+		if p.headerLen < minimalHeaderSize {
+			return n, NeedMore, nil
+		}
+		size := headerSize(p.header[secondHeaderByte])
+		copied := copy(p.header[p.headerLen:size], b[n:])
+		p.headerLen += copied
+		n += copied
+		if p.headerLen < size {
+			return n, NeedMore, nil
+		}
+		if err := parseHeader(p.header[:size], &p.frame); err != nil {
+			p.err = err
+			return n, NeedMore, err
+		}
+		p.headerLen = 0
+		p.inPayload = true
+		p.remaining = p.frame.PayloadLength
+		p.pos = 0
+		p.streaming = p.frame.PayloadLength > uint64(p.streamThreshold())
+		if p.streaming {
+			return n, HeaderParsed, nil
+		}
+		p.payload = p.payload[:0]
+	}
+
+	if p.streaming {
+		if n == len(b) {
+			return n, NeedMore, nil
+		}
+		chunk := b[n : n+int(min(uint64(len(b)-n), p.remaining))]
+		if p.frame.Masked {
+			p.pos = MaskBytes(p.frame.MaskingKey, p.pos, chunk)
+		}
+		p.remaining -= uint64(len(chunk))
+		p.inPayload = p.remaining > 0
+		p.frame.PayloadData = chunk
+		return n + len(chunk), PayloadChunk, nil
+	}
+
+	rest := b[n : n+int(min(uint64(len(b)-n), p.remaining))]
+	p.payload = append(p.payload, rest...)
+	p.remaining -= uint64(len(rest))
+	n += len(rest)
+	if p.remaining > 0 {
+		return n, NeedMore, nil
+	}
+	if p.frame.Masked {
+		MaskBytes(p.frame.MaskingKey, 0, p.payload)
+	}
+	p.frame.PayloadData = p.payload
+	p.inPayload = false
+	return n, FrameParsed, nil
+}
+
+// Frame returns the frame of the last event. It is reused by the next call of Feed.
+func (p *Parser) Frame() *Frame {
+	return &p.frame
+}
+
+// Remaining returns the number of payload bytes of the current streamed frame which were not parsed yet.
+func (p *Parser) Remaining() uint64 {
+	if !p.streaming {
+		return 0
+	}
+	return p.remaining
+}
+
+// Reset discards the partially parsed frame and the error of the Parser, keeping its payload buffer.
+func (p *Parser) Reset() {
+	*p = Parser{StreamThreshold: p.StreamThreshold, payload: p.payload[:0]}
+}
+
+// streamThreshold returns the largest payload of a frame which is emitted complete.
+func (p *Parser) streamThreshold() int {
+	if p.StreamThreshold <= 0 {
+		return defaultStreamThreshold
+	}
+	return p.StreamThreshold
+}
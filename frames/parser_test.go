@@ -0,0 +1,131 @@
+package frames
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+// feedAll feeds stream to p in random chunks and returns the parsed frames, joining the chunks of streamed payloads.
+func feedAll(t *testing.T, p *Parser, stream []byte, rng *rand.Rand) []Frame {
+	t.Helper()
+	var parsed []Frame
+	for len(stream) > 0 {
+		chunk := stream[:1+rng.IntN(min(len(stream), 3000))]
+		stream = stream[len(chunk):]
+		for len(chunk) > 0 {
+			n, event, err := p.Feed(chunk)
+			if err != nil {
+				t.Fatalf("Feed() error = %v", err)
+			}
+			if n == 0 && event == NeedMore {
+				t.Fatal("Feed() made no progress")
+			}
+			chunk = chunk[n:]
+			switch event {
+			case FrameParsed:
+				f := *p.Frame()
+				f.PayloadData = bytes.Clone(f.PayloadData)
+				parsed = append(parsed, f)
+			case HeaderParsed:
+				f := *p.Frame()
+				f.PayloadData = []byte{}
+				parsed = append(parsed, f)
+			case PayloadChunk:
+				last := &parsed[len(parsed)-1]
+				last.PayloadData = append(last.PayloadData, p.Frame().PayloadData...)
+			}
+		}
+	}
+	return parsed
+}
+
+func TestParserMatchesDecoder(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	for range 100 {
+		var stream []byte
+		for range 5 {
+			payload := make([]byte, []int{0, 5, 125, 126, 1000, 70000, 200000}[rng.IntN(7)])
+			for i := range payload {
+				payload[i] = byte(rng.Uint32())
+			}
+			opcode := []Opcode{OpText, OpBinary, OpContinuation, OpPing}[rng.IntN(4)]
+			f, err := NewFrame(rng.IntN(2) == 0, opcode, payload, rng.IntN(2) == 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Rsv1 = rng.IntN(2) == 0
+			encoded, _ := f.MarshalBinary()
+			stream = append(stream, encoded...)
+		}
+		// Streamed payloads are unmasked in place, so the Decoder reads a copy of the stream.
+		d := NewDecoder(bytes.NewReader(bytes.Clone(stream)))
+		p := &Parser{StreamThreshold: []int{0, 100, 1 << 30}[rng.IntN(3)]}
+		parsed := feedAll(t, p, stream, rng)
+		if len(parsed) != 5 {
+			t.Fatalf("Parser parsed %d frames, want 5", len(parsed))
+		}
+		for i, got := range parsed {
+			var want Frame
+			if err := d.Decode(&want); err != nil {
+				t.Fatal(err)
+			}
+			if got.Fin != want.Fin || got.Rsv1 != want.Rsv1 || got.OpCode != want.OpCode || got.Masked != want.Masked ||
+				got.MaskingKey != want.MaskingKey || got.PayloadLength != want.PayloadLength ||
+				!bytes.Equal(got.PayloadData, want.PayloadData) {
+				t.Fatalf("frame %d: Parser = %v %d bytes, Decoder = %v %d bytes", i, got.OpCode, len(got.PayloadData),
+					want.OpCode, len(want.PayloadData))
+			}
+		}
+	}
+}
+
+func TestParserEvents(t *testing.T) {
+	var p Parser
+	// Feed stops after the first frame and leaves the next one unconsumed.
+	n, event, err := p.Feed([]byte{0x81, 0x02, 'h', 'i', 0x81})
+	if n != 4 || event != FrameParsed || err != nil || string(p.Frame().PayloadData) != "hi" {
+		t.Errorf("Feed() = %d, %v, %v with %q, want the first frame", n, event, err, p.Frame().PayloadData)
+	}
+	if n, event, err = p.Feed([]byte{0x81}); n != 1 || event != NeedMore || err != nil {
+		t.Errorf("Feed() of a partial header = %d, %v, %v, want NeedMore", n, event, err)
+	}
+
+	p = Parser{StreamThreshold: 2}
+	if _, event, _ = p.Feed([]byte{0x82, 0x04}); event != HeaderParsed || p.Remaining() != 4 {
+		t.Errorf("Feed() of a large header = %v with %d remaining, want HeaderParsed with 4", event, p.Remaining())
+	}
+	if _, event, _ = p.Feed([]byte{1, 2, 3}); event != PayloadChunk || p.Remaining() != 1 {
+		t.Errorf("Feed() of a chunk = %v with %d remaining, want PayloadChunk with 1", event, p.Remaining())
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	var p Parser
+	// The most significant bit of a 64-bit payload length must be 0.
+	invalid := []byte{0x82, 127, 0x80, 0, 0, 0, 0, 0, 0, 0}
+	if _, _, err := p.Feed(invalid); err == nil {
+		t.Fatal("Feed() of an invalid header error = nil")
+	}
+	if n, _, err := p.Feed([]byte{0x81, 0x00}); n != 0 || err == nil {
+		t.Errorf("Feed() after an error = %d, %v, want the error again", n, err)
+	}
+	p.Reset()
+	if _, event, err := p.Feed([]byte{0x81, 0x00}); event != FrameParsed || err != nil {
+		t.Errorf("Feed() after Reset() = %v, %v, want FrameParsed", event, err)
+	}
+}
+
+func BenchmarkParser(b *testing.B) {
+	f, _ := NewFrame(true, OpBinary, make([]byte, 1000), true)
+	encoded, _ := f.MarshalBinary()
+	var p Parser
+	b.ReportAllocs()
+	b.SetBytes(int64(len(encoded)))
+	for b.Loop() {
+		for data := encoded; len(data) > 0; {
+			n, _, _ := p.Feed(data)
+			data = data[n:]
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package websock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// maxErrorBodySize is the number of bytes of a failed handshake response body which are kept for the caller.
+	maxErrorBodySize int64 = 1024
+	// defaultBufferSize is the size of the connection buffers when the Dialer does not specify one.
+	defaultBufferSize int = 4096
+)
+
+// ErrBadHandshake is returned by Dialer.Dial when the server response is not a valid opening handshake response.
+var ErrBadHandshake = errors.New("bad handshake")
+
+// Dialer holds the options for connecting to a WebSocket server.
+type Dialer struct {
+	// NetDialContext dials the TCP connection to the server or proxy. If nil, a net.Dialer is used.
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Proxy returns the URL of the proxy for a handshake request, or nil for a direct connection. The request URL has
+	// the http or https scheme, so http.ProxyFromEnvironment honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY, and
+	// http.ProxyURL selects a fixed proxy. Supported proxy schemes are http and https, which use an HTTP CONNECT
+	// tunnel, and socks5 and socks5h. User information of the proxy URL is used to authenticate.
+	Proxy func(req *http.Request) (*url.URL, error)
+	// TLSClientConfig configures the TLS client of wss connections. If nil, the default configuration is used.
+	TLSClientConfig *tls.Config
+	// HandshakeTimeout bounds the time spent connecting and performing the opening handshake. Zero means no timeout.
+	HandshakeTimeout time.Duration
+	// ReadBufferSize is the size in bytes of the connection read buffer. Zero means a default size.
+	ReadBufferSize int
+	// WriteBufferSize is the size in bytes of the connection write buffer. Zero means a default size.
+	WriteBufferSize int
+	// Subprotocols lists the subprotocols offered to the server in order of preference.
+	Subprotocols []string
+	// Compression offers the permessage-deflate extension (RFC 7692). If nil, messages are never compressed.
+	Compression *CompressionOptions
+	// Extensions lists the extensions offered to the server, after permessage-deflate offered by Compression.
+	Extensions []ClientExtension
+	// MaxFrameSize limits the payload size of incoming frames, see WebSocket.SetMaxFrameSize. Zero means no limit.
+	MaxFrameSize int64
+	// MaxMessageSize limits the payload size of incoming messages, see WebSocket.SetMaxMessageSize. Zero means no
+	// limit.
+	MaxMessageSize int64
+	// Keepalive enables automatic keepalive pings, see WebSocket.StartKeepalive. If nil, no pings are sent.
+	Keepalive *KeepaliveOptions
+	// CloseTimeout bounds the time Close waits for the peer's close frame, see WebSocket.SetCloseTimeout. Zero means
+	// 5 seconds.
+	CloseTimeout time.Duration
+}
+
+// DefaultDialer is a Dialer with default options, which uses the proxy configured in the environment.
+var DefaultDialer = &Dialer{
+	Proxy:            http.ProxyFromEnvironment,
+	HandshakeTimeout: 45 * time.Second,
+}
+
+// Dial connects to the WebSocket server at urlStr, a ws:// or wss:// URL, and performs the opening handshake. Headers
+// in header are added to the handshake request, except for those that are managed by the handshake itself. The server
+// response is returned even if the handshake fails, with up to the first kilobyte of its body.
+func (d *Dialer) Dial(ctx context.Context, urlStr string, header http.Header) (*WebSocket, *http.Response, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	default:
+		return nil, nil, fmt.Errorf("malformed ws or wss URL %q", urlStr)
+	}
+	if u.User != nil {
+		return nil, nil, errors.New("user information in a WebSocket URL is not supported")
+	}
+	u.Fragment = ""
+
+	if d.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+
+	key, err := generateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	req := (&http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}).WithContext(ctx)
+	for name, values := range header {
+		switch {
+		case isHandshakeHeader(name):
+			return nil, nil, fmt.Errorf("header %s is managed by the handshake", name)
+		case http.CanonicalHeaderKey(name) == "Host" && len(values) > 0:
+			req.Host = values[0]
+		default:
+			req.Header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+	extensions := d.extensions()
+	if offers := offerExtensions(extensions); offers != "" {
+		req.Header.Set("Sec-WebSocket-Extensions", offers)
+	}
+
+	proxyURL, err := d.proxy(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	netDial := d.NetDialContext
+	if netDial == nil {
+		netDial = (&net.Dialer{}).DialContext
+	}
+	addr := hostPort(u)
+	if proxyURL != nil {
+		addr = proxyHostPort(proxyURL)
+	}
+	rawConn, err := netDial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn := rawConn
+	stop := context.AfterFunc(ctx, func() {
+		_ = rawConn.SetDeadline(time.Unix(1, 0))
+	})
+	fail := func(resp *http.Response, err error) (*WebSocket, *http.Response, error) {
+		stop()
+		_ = rawConn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return nil, resp, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = rawConn.SetDeadline(deadline); err != nil {
+			return fail(nil, err)
+		}
+	}
+	if proxyURL != nil {
+		if conn, err = d.proxyHandshake(ctx, conn, proxyURL, hostPort(u)); err != nil {
+			return fail(nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err))
+		}
+	}
+	if u.Scheme == "https" {
+		if conn, err = d.tlsHandshake(ctx, conn, u); err != nil {
+			return fail(nil, err)
+		}
+	}
+
+	buff := bufio.NewReadWriter(
+		bufio.NewReaderSize(conn, bufferSize(d.ReadBufferSize)),
+		bufio.NewWriterSize(conn, bufferSize(d.WriteBufferSize)),
+	)
+	if err = req.Write(buff); err != nil {
+		return fail(nil, err)
+	}
+	if err = buff.Flush(); err != nil {
+		return fail(nil, err)
+	}
+	resp, err := http.ReadResponse(buff.Reader, req)
+	if err != nil {
+		return fail(nil, err)
+	}
+
+	if err = checkHandshakeResponse(resp, key); err != nil {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return fail(resp, err)
+	}
+	subprotocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !slices.Contains(d.Subprotocols, subprotocol) {
+		return fail(resp, fmt.Errorf("%w: server selected subprotocol %q which was not offered", ErrBadHandshake, subprotocol))
+	}
+	chain, err := confirmExtensions(extensions, resp.Header)
+	if err != nil {
+		return fail(resp, fmt.Errorf("%w: %w", ErrBadHandshake, err))
+	}
+	resp.Body = http.NoBody
+
+	if !stop() {
+		return fail(resp, ctx.Err())
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		return fail(resp, err)
+	}
+	ws := &WebSocket{
+		Conn:             conn,
+		buff:             buff,
+		header:           resp.Header,
+		status:           frames.NormalClosure,
+		subprotocol:      subprotocol,
+		extensions:       chain,
+		extensionsHeader: resp.Header.Get("Sec-WebSocket-Extensions"),
+		maxFrameSize:     d.MaxFrameSize,
+		maxMessageSize:   d.MaxMessageSize,
+		closeTimeout:     d.CloseTimeout,
+	}
+	if d.Keepalive != nil {
+		if err = ws.StartKeepalive(*d.Keepalive); err != nil {
+			return fail(resp, err)
+		}
+	}
+	return ws, resp, nil
+}
+
+// extensions returns the extensions offered by the Dialer.
+func (d *Dialer) extensions() []ClientExtension {
+	if d.Compression == nil {
+		return d.Extensions
+	}
+	return append([]ClientExtension{deflateExtension{opts: d.Compression}}, d.Extensions...)
+}
+
+// proxy returns the URL of the proxy to use for the handshake request, or nil if the connection is direct.
+func (d *Dialer) proxy(req *http.Request) (*url.URL, error) {
+	if d.Proxy == nil {
+		return nil, nil
+	}
+	return d.Proxy(req)
+}
+
+// tlsHandshake wraps the connection to the server of u in TLS.
+func (d *Dialer) tlsHandshake(ctx context.Context, conn net.Conn, u *url.URL) (net.Conn, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if d.TLSClientConfig != nil {
+		cfg = d.TLSClientConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = u.Hostname()
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// checkHandshakeResponse validates the server response to an opening handshake with the given key.
+func checkHandshakeResponse(resp *http.Response, key string) error {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("%w: unexpected status %s", ErrBadHandshake, resp.Status)
+	}
+	if !tokenListContains(resp.Header, "Upgrade", "websocket") || !tokenListContains(resp.Header, "Connection", "upgrade") {
+		return fmt.Errorf("%w: missing Upgrade or Connection header", ErrBadHandshake)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return fmt.Errorf("%w: invalid Sec-WebSocket-Accept", ErrBadHandshake)
+	}
+	return nil
+}
+
+// bufferSize returns size, or the default buffer size if size is not positive.
+func bufferSize(size int) int {
+	if size <= 0 {
+		return defaultBufferSize
+	}
+	return size
+}
+
+// hostPort returns the host and port of u, using the default port of its scheme if it has none.
+func hostPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// generateKey returns a random Sec-WebSocket-Key.
+func generateKey() (string, error) {
+	var key [16]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key[:]), nil
+}
+
+// acceptKey computes the Sec-WebSocket-Accept value of a Sec-WebSocket-Key per RFC 6455 section 4.2.2.
+func acceptKey(key string) string {
+	sha1Hash := sha1.New()
+	sha1Hash.Write([]byte(key))
+	sha1Hash.Write([]byte(websocketGUID))
+	return base64.StdEncoding.EncodeToString(sha1Hash.Sum(nil))
+}
@@ -0,0 +1,171 @@
+package websock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDialEcho(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		headers <- r.Header
+		echo(ws, r)
+	})
+	ws, _, err := (&Dialer{}).Dial(context.Background(), url, http.Header{"X-Token": {"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.closeConn()
+	if got := (<-headers).Get("X-Token"); got != "secret" {
+		t.Errorf("X-Token = %q, want %q", got, "secret")
+	}
+	data := bytes.Repeat([]byte("binary"), 20000)
+	want := bytes.Clone(data)
+	if err = ws.WriteBinaryMessage(data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatal("WriteBinaryMessage() modified the payload of the caller")
+	}
+	if messageType, got, err := ws.ReadMessage(); err != nil || messageType != frames.OpBinary || !bytes.Equal(got, want) {
+		t.Fatalf("ReadMessage() = %v, %d bytes, %v", messageType, len(got), err)
+	}
+}
+
+func TestDialTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.closeConn()
+		echo(ws, r)
+	}))
+	defer srv.Close()
+	d := &Dialer{TLSClientConfig: srv.Client().Transport.(*http.Transport).TLSClientConfig}
+	ws := dial(t, d, "wss"+strings.TrimPrefix(srv.URL, "https"))
+	if err := ws.WriteTextMessage("over TLS"); err != nil {
+		t.Fatal(err)
+	}
+	if _, data, err := ws.ReadMessage(); err != nil || string(data) != "over TLS" {
+		t.Fatalf("ReadMessage() = %q, %v", data, err)
+	}
+}
+
+func TestDialBadHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "no websockets here", http.StatusNotFound)
+			},
+		},
+		{
+			name: "accept",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Upgrade", "websocket")
+				w.Header().Set("Connection", "Upgrade")
+				w.Header().Set("Sec-WebSocket-Accept", acceptKey(testKey))
+				w.WriteHeader(http.StatusSwitchingProtocols)
+			},
+		},
+		{
+			name: "subprotocol",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Upgrade", "websocket")
+				w.Header().Set("Connection", "Upgrade")
+				w.Header().Set("Sec-WebSocket-Accept", acceptKey(r.Header.Get("Sec-WebSocket-Key")))
+				w.Header().Set("Sec-WebSocket-Protocol", "not-offered")
+				w.WriteHeader(http.StatusSwitchingProtocols)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			_, resp, err := (&Dialer{}).Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+			if !errors.Is(err, ErrBadHandshake) {
+				t.Fatalf("Dial() error = %v, want %v", err, ErrBadHandshake)
+			}
+			if resp == nil {
+				t.Fatal("Dial() returned no response")
+			}
+			if tt.name == "status" {
+				body, _ := io.ReadAll(resp.Body)
+				if resp.StatusCode != http.StatusNotFound || !strings.Contains(string(body), "no websockets here") {
+					t.Errorf("response = %d %q", resp.StatusCode, body)
+				}
+			}
+		})
+	}
+}
+
+func TestDialInvalidArguments(t *testing.T) {
+	for _, tt := range []struct {
+		url    string
+		header http.Header
+	}{
+		{url: "http://example.com/"},
+		{url: "ws://user:password@example.com/"},
+		{url: "ws://example.com/", header: http.Header{"Sec-WebSocket-Protocol": {"chat"}}},
+	} {
+		if _, _, err := (&Dialer{}).Dial(context.Background(), tt.url, tt.header); err == nil {
+			t.Errorf("Dial(%q, %v) error = nil", tt.url, tt.header)
+		}
+	}
+}
+
+func TestClientRejectsMaskedFrames(t *testing.T) {
+	closeCode := make(chan frames.WebSocketStatusCode, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.closeConn()
+		frame, _ := frames.NewClientFrame(true, frames.OpText, []byte("masked"))
+		encoded, _ := frame.MarshalBinary()
+		_, _ = ws.Conn.Write(encoded)
+		reply, err := ws.ReadFrame()
+		if err != nil {
+			closeCode <- 0
+			return
+		}
+		code, _, _ := reply.ReadCloseFrame()
+		closeCode <- code
+	}))
+	defer srv.Close()
+	ws := dial(t, &Dialer{}, "ws"+strings.TrimPrefix(srv.URL, "http"))
+	if _, _, err := ws.ReadMessage(); err == nil {
+		t.Fatal("ReadMessage() error = nil, want an error for a masked server frame")
+	}
+	if code := <-closeCode; code != frames.ProtocolError {
+		t.Errorf("close status = %v, want %v", code, frames.ProtocolError)
+	}
+}
+
+func TestClientMasksFrames(t *testing.T) {
+	masked := make(chan bool, 1)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		frame, err := ws.ReadFrame()
+		masked <- err == nil && frame.Masked && string(frame.PayloadData) == "mask me"
+	})
+	ws := dial(t, &Dialer{}, url)
+	if err := ws.WriteTextMessage("mask me"); err != nil {
+		t.Fatal(err)
+	}
+	if !<-masked {
+		t.Error("server did not receive a masked frame")
+	}
+}
@@ -0,0 +1,219 @@
+package websock
+
+import (
+	"errors"
+	"fmt"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"net"
+	"time"
+)
+
+// defaultCloseTimeout is the time Close waits for the peer's close frame when no close timeout is set.
+const defaultCloseTimeout time.Duration = 5 * time.Second
+
+// ErrConnectionClosing is returned by writes once a close frame was sent or received.
+var ErrConnectionClosing = errors.New("connection is closing")
+
+// connState is a state of the closing handshake of a connection.
+type connState int
+
+const (
+	// stateOpen is the state of a connection before a close frame is sent or received.
+	stateOpen connState = iota
+	// stateClosing is the state of a connection once a close frame was sent or received.
+	stateClosing
+	// stateClosed is the state of a connection once the underlying connection is closed.
+	stateClosed
+)
+
+// CloseError is returned by reads once the connection was closed by a closing handshake. Code and Reason are the status
+// code and reason of the close frame which started the handshake. Code is frames.NoStatusCode1005 if that close frame
+// had no status code.
+type CloseError struct {
+	Code   frames.WebSocketStatusCode
+	Reason string
+	// Remote is true if the peer started the closing handshake and false if it was started locally.
+	Remote bool
+}
+
+func (e *CloseError) Error() string {
+	side := "locally"
+	if e.Remote {
+		side = "by the peer"
+	}
+	if e.Reason == "" {
+		return fmt.Sprintf("connection closed %s with status %d (%v)", side, e.Code, e.Code)
+	}
+	return fmt.Sprintf("connection closed %s with status %d (%v): %s", side, e.Code, e.Code, e.Reason)
+}
+
+// SetCloseTimeout sets the time Close waits for the peer's close frame before the connection is closed anyway. Zero
+// or a negative timeout means 5 seconds.
+func (ws *WebSocket) SetCloseTimeout(timeout time.Duration) {
+	ws.closeTimeout = timeout
+}
+
+// awaitClose waits up to the close timeout for the peer's close frame, after a close frame was sent. While the
+// application reads a message, the close frame is left to its reads. Otherwise the connection is read until the close
+// frame arrives, discarding data messages.
+func (ws *WebSocket) awaitClose() {
+	timeout := ws.closeTimeout
+	if timeout <= 0 {
+		timeout = defaultCloseTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	select {
+	case <-ws.closeReceived():
+		return
+	default:
+	}
+	if ws.readMu.TryLock() {
+		// A message which is not read to the end yet belongs to a reader of the application.
+		idle := ws.reader == nil || !ws.reader.unfinished()
+		if idle {
+			ws.discardUntilClose(deadline)
+		}
+		ws.readMu.Unlock()
+		if idle {
+			return
+		}
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-ws.closeReceived():
+	case <-timer.C:
+	}
+}
+
+// discardUntilClose discards data messages until the peer's close frame arrives, the read fails or the deadline
+// expires, for the goroutine which holds the read lock.
+func (ws *WebSocket) discardUntilClose(deadline time.Time) {
+	_ = ws.Conn.SetReadDeadline(deadline)
+	for {
+		_, r, err := ws.nextReader()
+		if err != nil {
+			return
+		}
+		if _, err = io.Copy(io.Discard, readerFunc(r.read)); err != nil {
+			return
+		}
+	}
+}
+
+// checkWrite refuses frames once a close frame was sent or received, except for a single close frame. A close frame
+// written while the connection is open starts the closing handshake.
+func (ws *WebSocket) checkWrite(frame *frames.Frame) error {
+	ws.closeMu.Lock()
+	defer ws.closeMu.Unlock()
+	if frame.OpCode != frames.OpClose {
+		if ws.state != stateOpen {
+			return ErrConnectionClosing
+		}
+		return nil
+	}
+	if ws.closeSent || ws.state == stateClosed {
+		return ErrConnectionClosing
+	}
+	ws.closeSent = true
+	if ws.state == stateOpen {
+		ws.state = stateClosing
+		if ws.closeErr == nil {
+			code, reason, _ := frame.ReadCloseFrame()
+			ws.closeErr = &CloseError{Code: code, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// receiveClose records a close frame received from the peer. It returns true if the peer started the closing
+// handshake, false if the close frame answers a close frame which was sent.
+func (ws *WebSocket) receiveClose(code frames.WebSocketStatusCode, reason string) bool {
+	ws.closeMu.Lock()
+	defer ws.closeMu.Unlock()
+	remote := ws.state == stateOpen
+	if remote {
+		ws.state = stateClosing
+		ws.closeErr = &CloseError{Code: code, Reason: reason, Remote: true}
+	}
+	if ws.closeRecv == nil {
+		ws.closeRecv = make(chan struct{})
+	}
+	select {
+	case <-ws.closeRecv:
+	default:
+		close(ws.closeRecv)
+	}
+	return remote
+}
+
+// closeReceived returns a channel which is closed once the peer's close frame is received.
+func (ws *WebSocket) closeReceived() <-chan struct{} {
+	ws.closeMu.Lock()
+	defer ws.closeMu.Unlock()
+	if ws.closeRecv == nil {
+		ws.closeRecv = make(chan struct{})
+	}
+	return ws.closeRecv
+}
+
+// setCloseErr records why the connection ended, unless a reason was already recorded.
+func (ws *WebSocket) setCloseErr(err error) {
+	ws.closeMu.Lock()
+	defer ws.closeMu.Unlock()
+	if ws.closeErr == nil {
+		ws.closeErr = err
+	}
+}
+
+// closedErr returns why the connection ended, or nil if it is not closed yet.
+func (ws *WebSocket) closedErr() error {
+	ws.closeMu.Lock()
+	defer ws.closeMu.Unlock()
+	if ws.state != stateClosed {
+		return nil
+	}
+	return ws.closeErr
+}
+
+// closeConn closes the underlying connection, stops the keepalive pings and runs the close hooks. Only the first call closes the connection.
+func (ws *WebSocket) closeConn() error {
+	ws.closeMu.Lock()
+	if ws.state == stateClosed {
+		ws.closeMu.Unlock()
+		return nil
+	}
+	ws.state = stateClosed
+	if ws.closeErr == nil {
+		ws.closeErr = net.ErrClosed
+	}
+	hooks := ws.closeHooks
+	ws.closeHooks = nil
+	ws.closeMu.Unlock()
+	ws.stopKeepalive()
+	err := ws.Conn.Close()
+	for _, hook := range hooks {
+		hook()
+	}
+	return err
+}
+
+// onClose registers hook to be called once the underlying connection is closed. It returns false without registering
+// the hook if the connection is already closed.
+func (ws *WebSocket) onClose(hook func()) bool {
+	ws.closeMu.Lock()
+	defer ws.closeMu.Unlock()
+	if ws.state == stateClosed {
+		return false
+	}
+	ws.closeHooks = append(ws.closeHooks, hook)
+	return true
+}
+
+// readerFunc adapts a read function to io.Reader.
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,204 @@
+package websock
+
+import (
+	"errors"
+	"fmt"
+	"github.com/blazskufca/gowebsock/frames"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCloseHandshake(t *testing.T) {
+	readErr := make(chan error, 1)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				readErr <- err
+				if err = ws.WriteTextMessage("late"); !errors.Is(err, ErrConnectionClosing) && err != nil {
+					t.Errorf("server WriteTextMessage() after the close error = %v", err)
+				}
+				return
+			}
+		}
+	})
+	ws := dial(t, &Dialer{}, url)
+	if err := ws.WriteTextMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.CloseWithCode(frames.GoingAway, "bye"); err != nil {
+		t.Fatalf("CloseWithCode() error = %v", err)
+	}
+	var closeErr *CloseError
+	if err := <-readErr; !errors.As(err, &closeErr) || *closeErr != (CloseError{Code: frames.GoingAway, Reason: "bye", Remote: true}) {
+		t.Errorf("server ReadMessage() error = %v, want a close by the peer", err)
+	}
+	if _, _, err := ws.ReadMessage(); !errors.As(err, &closeErr) || *closeErr != (CloseError{Code: frames.GoingAway, Reason: "bye"}) {
+		t.Errorf("ReadMessage() after Close error = %v, want a local close", err)
+	}
+	if err := ws.WriteTextMessage("late"); err == nil {
+		t.Error("WriteTextMessage() after Close error = nil")
+	}
+	if err := ws.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+}
+
+func TestServerInitiatedClose(t *testing.T) {
+	closed := make(chan error, 1)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		_ = ws.WriteTextMessage("hi")
+		closed <- ws.CloseWithCode(frames.ViolatesPolicy, "go away")
+	})
+	ws := dial(t, &Dialer{}, url)
+	if _, data, err := ws.ReadMessage(); err != nil || string(data) != "hi" {
+		t.Fatalf("ReadMessage() = %q, %v", data, err)
+	}
+	var closeErr *CloseError
+	if _, _, err := ws.ReadMessage(); !errors.As(err, &closeErr) || *closeErr != (CloseError{Code: frames.ViolatesPolicy, Reason: "go away", Remote: true}) {
+		t.Errorf("ReadMessage() error = %v, want a close by the peer", err)
+	}
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("server CloseWithCode() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("server CloseWithCode() did not return after the handshake")
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	// The server never reads, so the close frame is not answered.
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		<-release
+	})
+	t.Cleanup(func() { close(release) })
+	ws := dial(t, &Dialer{CloseTimeout: 50 * time.Millisecond}, url)
+	start := time.Now()
+	if err := ws.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Close() returned after %v, want the close timeout", elapsed)
+	}
+	if ws.closedErr() == nil {
+		t.Error("Close() did not close the underlying connection")
+	}
+}
+
+func TestCloseWithCodeAfterCloseFrame(t *testing.T) {
+	release := make(chan struct{})
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		<-release
+		_, _, _ = ws.ReadMessage()
+	})
+	ws := dial(t, &Dialer{}, url)
+	if err := ws.WriteCloseMessage(frames.GoingAway, ""); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(20*time.Millisecond, func() { close(release) })
+	start := time.Now()
+	// No second close frame is sent, but the handshake is still awaited before the connection is closed.
+	if err := ws.CloseWithCode(frames.NormalClosure, ""); err != nil {
+		t.Fatalf("CloseWithCode() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("CloseWithCode() returned after %v, before the peer answered", elapsed)
+	}
+	var closeErr *CloseError
+	if err := ws.closedErr(); !errors.As(err, &closeErr) || closeErr.Code != frames.GoingAway || closeErr.Remote {
+		t.Errorf("connection ended with %v, want the local close with %v", err, frames.GoingAway)
+	}
+	select {
+	case <-ws.closeReceived():
+	default:
+		t.Error("CloseWithCode() returned before the peer's close frame arrived")
+	}
+}
+
+func TestCloseWithConcurrentReader(t *testing.T) {
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		_, _, _ = ws.ReadMessage()
+	})
+	ws := dial(t, &Dialer{}, url)
+	readErr := make(chan error, 1)
+	go func() {
+		_, _, err := ws.ReadMessage()
+		readErr <- err
+	}()
+	if err := ws.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	var closeErr *CloseError
+	if err := <-readErr; !errors.As(err, &closeErr) || closeErr.Code != frames.NormalClosure || closeErr.Remote {
+		t.Errorf("concurrent ReadMessage() error = %v, want the local close", err)
+	}
+}
+
+func TestCloseWithReadLoop(t *testing.T) {
+	data := make([]byte, 64<<10)
+	for range 20 {
+		url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for ws.WriteBinaryMessage(data) == nil {
+				}
+			}()
+			for {
+				if _, _, err := ws.ReadMessage(); err != nil {
+					break
+				}
+			}
+			_ = ws.closeConn()
+			<-done
+		})
+		ws := dial(t, &Dialer{}, url)
+		readErr := make(chan error, 1)
+		reading := make(chan struct{})
+		go func() {
+			for i := 0; ; i++ {
+				_, got, err := ws.ReadMessage()
+				if err != nil {
+					readErr <- err
+					return
+				}
+				if len(got) != len(data) {
+					readErr <- fmt.Errorf("received %d bytes, want %d", len(got), len(data))
+					return
+				}
+				if i == 0 {
+					close(reading)
+				}
+			}
+		}()
+		<-reading
+		if err := ws.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+		// Close never takes over a message which the read loop is reading.
+		var closeErr *CloseError
+		if err := <-readErr; !errors.As(err, &closeErr) || closeErr.Code != frames.NormalClosure || closeErr.Remote {
+			t.Fatalf("ReadMessage() loop ended with %v, want the local close", err)
+		}
+	}
+}
+
+func TestEmptyCloseFrame(t *testing.T) {
+	echoed := make(chan *frames.Frame, 1)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		_, _ = ws.Conn.Write([]byte{0x88, 0x00})
+		frame, _ := ws.ReadFrame()
+		echoed <- frame
+	})
+	ws := dial(t, &Dialer{}, url)
+	var closeErr *CloseError
+	if _, _, err := ws.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != frames.NoStatusCode1005 {
+		t.Errorf("ReadMessage() error = %v, want a close with %v", err, frames.NoStatusCode1005)
+	}
+	if frame := <-echoed; frame == nil || frame.OpCode != frames.OpClose || frame.PayloadLength != 0 {
+		t.Errorf("echoed frame = %+v, want an empty close frame", frame)
+	}
+}
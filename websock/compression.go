@@ -0,0 +1,408 @@
+package websock
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"strconv"
+	"sync"
+)
+
+const (
+	permessageDeflate    string = "permessage-deflate"
+	serverNoContextParam string = "server_no_context_takeover"
+	clientNoContextParam string = "client_no_context_takeover"
+	serverMaxWindowParam string = "server_max_window_bits"
+	clientMaxWindowParam string = "client_max_window_bits"
+	minWindowBits        int    = 8
+	maxWindowBits        int    = 15
+	deflateSyncTailSize  int    = 4
+)
+
+var (
+	// deflateReadTail is appended to a compressed message before decompression. It restores the stripped sync flush
+	// tail (RFC 7692 section 7.2.2) and adds an empty final block so the decompressor ends with io.EOF.
+	deflateReadTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+	// flateWriterPools holds flate writers without context takeover, indexed by compression level.
+	flateWriterPools [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+	// flateReaderPool holds flate readers without context takeover.
+	flateReaderPool sync.Pool
+)
+
+// CompressionOptions configures the permessage-deflate extension (RFC 7692).
+type CompressionOptions struct {
+	// Level is the compress/flate compression level of outgoing messages. Zero means flate.DefaultCompression.
+	Level int
+	// ServerNoContextTakeover resets the server compressor after every message, trading ratio for memory.
+	ServerNoContextTakeover bool
+	// ClientNoContextTakeover asks the client to reset its compressor after every message.
+	ClientNoContextTakeover bool
+	// ClientMaxWindowBits limits the LZ77 window of the client compressor to 2^ClientMaxWindowBits bytes when the
+	// client supports it. Zero means no limit.
+	ClientMaxWindowBits int
+}
+
+// level returns the flate level to compress with.
+func (o *CompressionOptions) level() (int, error) {
+	if o.Level == 0 {
+		return flate.DefaultCompression, nil
+	}
+	if o.Level < flate.HuffmanOnly || o.Level > flate.BestCompression {
+		return 0, fmt.Errorf("invalid compression level %d", o.Level)
+	}
+	return o.Level, nil
+}
+
+// parseWindowBits parses a max_window_bits parameter value.
+func parseWindowBits(value string) (int, bool) {
+	bits, err := strconv.Atoi(value)
+	if err != nil || bits < minWindowBits || bits > maxWindowBits || strconv.Itoa(bits) != value {
+		return 0, false
+	}
+	return bits, true
+}
+
+// DeflateExtension returns the permessage-deflate Extension (RFC 7692) configured by opts.
+func DeflateExtension(opts *CompressionOptions) Extension {
+	return deflateExtension{opts: opts}
+}
+
+// deflateExtension negotiates permessage-deflate on both the server and the client.
+type deflateExtension struct {
+	opts *CompressionOptions
+}
+
+func (e deflateExtension) Name() string {
+	return permessageDeflate
+}
+
+func (e deflateExtension) Accept(offer ExtensionParams) (ExtensionParams, ExtensionConn, bool) {
+	level, err := e.opts.level()
+	if err != nil {
+		return nil, nil, false
+	}
+	response, d, ok := acceptDeflateOffer(e.opts, offer)
+	if !ok {
+		return nil, nil, false
+	}
+	d.level = level
+	return response, d, true
+}
+
+func (e deflateExtension) Offer() ExtensionParams {
+	offer := make(ExtensionParams)
+	if e.opts.ServerNoContextTakeover {
+		offer[serverNoContextParam] = ""
+	}
+	if e.opts.ClientNoContextTakeover {
+		offer[clientNoContextParam] = ""
+	}
+	return offer
+}
+
+func (e deflateExtension) Confirm(response ExtensionParams) (ExtensionConn, error) {
+	level, err := e.opts.level()
+	if err != nil {
+		return nil, err
+	}
+	d := &deflateConn{
+		level:                  level,
+		writeNoContextTakeover: e.opts.ClientNoContextTakeover,
+		readWindowBits:         maxWindowBits,
+	}
+	for name := range response {
+		switch name {
+		case serverNoContextParam:
+			d.readNoContextTakeover = true
+		case clientNoContextParam:
+			d.writeNoContextTakeover = true
+		case serverMaxWindowParam:
+			// The server may limit its window even though it was not offered (RFC 7692 section 7.1.2.1).
+			bits, ok := parseWindowBits(response[name])
+			if !ok {
+				return nil, fmt.Errorf("invalid %s %q in response", name, response[name])
+			}
+			d.readWindowBits = bits
+		case clientMaxWindowParam:
+			// The client window is not offered, so the server must not include it (RFC 7692 section 7.1.2.2).
+			return nil, fmt.Errorf("unexpected %s in response", name)
+		default:
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	if e.opts.ServerNoContextTakeover && !d.readNoContextTakeover {
+		return nil, fmt.Errorf("response is missing the offered %s", serverNoContextParam)
+	}
+	return d, nil
+}
+
+// acceptDeflateOffer negotiates a single permessage-deflate offer per RFC 7692 section 7.1.
+func acceptDeflateOffer(opts *CompressionOptions, params ExtensionParams) (ExtensionParams, *deflateConn, bool) {
+	d := &deflateConn{
+		writeNoContextTakeover: opts.ServerNoContextTakeover,
+		readNoContextTakeover:  opts.ClientNoContextTakeover,
+		readWindowBits:         maxWindowBits,
+	}
+	_, serverMaxWindowOffered := params[serverMaxWindowParam]
+	for name, value := range params {
+		switch name {
+		case serverNoContextParam:
+			if value != "" {
+				return nil, nil, false
+			}
+			d.writeNoContextTakeover = true
+		case clientNoContextParam:
+			if value != "" {
+				return nil, nil, false
+			}
+			d.readNoContextTakeover = true
+		case serverMaxWindowParam:
+			// compress/flate always uses a 32KiB window, so only the maximum window can be honoured.
+			if bits, ok := parseWindowBits(value); !ok || bits != maxWindowBits {
+				return nil, nil, false
+			}
+		case clientMaxWindowParam:
+			bits := maxWindowBits
+			if value != "" {
+				var ok bool
+				if bits, ok = parseWindowBits(value); !ok {
+					return nil, nil, false
+				}
+			}
+			if opts.ClientMaxWindowBits >= minWindowBits && opts.ClientMaxWindowBits < bits {
+				bits = opts.ClientMaxWindowBits
+			}
+			d.readWindowBits = bits
+		default:
+			return nil, nil, false
+		}
+	}
+	response := make(ExtensionParams)
+	if d.writeNoContextTakeover {
+		response[serverNoContextParam] = ""
+	}
+	if d.readNoContextTakeover {
+		response[clientNoContextParam] = ""
+	}
+	if serverMaxWindowOffered {
+		response[serverMaxWindowParam] = strconv.Itoa(maxWindowBits)
+	}
+	if d.readWindowBits < maxWindowBits {
+		response[clientMaxWindowParam] = strconv.Itoa(d.readWindowBits)
+	}
+	return response, d, true
+}
+
+// deflateConn is the per-connection state of a negotiated permessage-deflate extension.
+type deflateConn struct {
+	level                  int
+	writeNoContextTakeover bool
+	readNoContextTakeover  bool
+	readWindowBits         int
+	fw                     *flate.Writer
+	tw                     tailStripWriter
+	fr                     io.ReadCloser
+	history                []byte
+}
+
+func (d *deflateConn) ReservedBits() ReservedBits {
+	return RSV1
+}
+
+func (d *deflateConn) ReadMessage(_ frames.Opcode, rsv ReservedBits, r io.Reader) (io.Reader, error) {
+	if rsv&RSV1 == 0 {
+		return r, nil
+	}
+	return d.newReader(r), nil
+}
+
+func (d *deflateConn) WriteMessage(_ frames.Opcode, w io.WriteCloser) (io.WriteCloser, ReservedBits, error) {
+	cw, err := d.newWriter(w)
+	if err != nil {
+		return nil, 0, err
+	}
+	return cw, RSV1, nil
+}
+
+// ReadFrame rejects RSV1 on frames other than the first frame of a data message (RFC 7692 section 6.1).
+func (d *deflateConn) ReadFrame(fr *frames.Frame) error {
+	if fr.Rsv1 && fr.OpCode != frames.OpText && fr.OpCode != frames.OpBinary {
+		return errors.New("protocol error: RSV1 bit set on a control or continuation frame")
+	}
+	return nil
+}
+
+func (d *deflateConn) WriteFrame(*frames.Frame) error {
+	return nil
+}
+
+// newWriter returns a writer which compresses a single message into dst and closes dst when it is closed.
+func (d *deflateConn) newWriter(dst io.WriteCloser) (io.WriteCloser, error) {
+	d.tw = tailStripWriter{w: dst}
+	if d.fw != nil {
+		return &compressWriter{d: d, dst: dst}, nil
+	}
+	if d.writeNoContextTakeover {
+		if fw, ok := flateWriterPools[d.level-flate.HuffmanOnly].Get().(*flate.Writer); ok {
+			fw.Reset(&d.tw)
+			d.fw = fw
+			return &compressWriter{d: d, dst: dst}, nil
+		}
+	}
+	fw, err := flate.NewWriter(&d.tw, d.level)
+	if err != nil {
+		return nil, err
+	}
+	d.fw = fw
+	return &compressWriter{d: d, dst: dst}, nil
+}
+
+// newReader returns a reader of the decompressed message read from src.
+func (d *deflateConn) newReader(src io.Reader) io.Reader {
+	var dict []byte
+	if !d.readNoContextTakeover {
+		dict = d.history
+	}
+	src = io.MultiReader(src, bytes.NewReader(deflateReadTail))
+	if d.fr == nil {
+		if fr, ok := flateReaderPool.Get().(io.ReadCloser); ok {
+			d.fr = fr
+		} else {
+			d.fr = flate.NewReader(nil)
+		}
+	}
+	_ = d.fr.(flate.Resetter).Reset(src, dict)
+	return &decompressReader{d: d}
+}
+
+// release returns the pooled codecs which are not needed between messages.
+func (d *deflateConn) release() {
+	if d.writeNoContextTakeover && d.fw != nil {
+		flateWriterPools[d.level-flate.HuffmanOnly].Put(d.fw)
+		d.fw = nil
+	}
+	if d.readNoContextTakeover && d.fr != nil {
+		flateReaderPool.Put(d.fr)
+		d.fr = nil
+	}
+}
+
+// remember appends decompressed data to the history used as dictionary for the next message.
+func (d *deflateConn) remember(p []byte) {
+	if d.readNoContextTakeover {
+		return
+	}
+	window := 1 << d.readWindowBits
+	if len(p) >= window {
+		d.history = append(d.history[:0], p[len(p)-window:]...)
+		return
+	}
+	if excess := len(d.history) + len(p) - window; excess > 0 {
+		d.history = d.history[:copy(d.history, d.history[excess:])]
+	}
+	d.history = append(d.history, p...)
+}
+
+// compressWriter compresses a single message.
+type compressWriter struct {
+	d   *deflateConn
+	dst io.WriteCloser
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.d == nil {
+		return 0, errors.New("write to closed compressed message")
+	}
+	return w.d.fw.Write(p)
+}
+
+func (w *compressWriter) Close() error {
+	if w.d == nil {
+		return nil
+	}
+	d := w.d
+	w.d = nil
+	err := d.fw.Flush()
+	if err == nil && !d.tw.complete() {
+		err = errors.New("compressed message is missing the sync flush tail")
+	}
+	d.release()
+	if err != nil {
+		return err
+	}
+	return w.dst.Close()
+}
+
+// decompressReader decompresses a single message.
+type decompressReader struct {
+	d *deflateConn
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	if r.d == nil {
+		return 0, io.EOF
+	}
+	n, err := r.d.fr.Read(p)
+	r.d.remember(p[:n])
+	if err == io.EOF {
+		r.d.release()
+		r.d = nil
+	} else if err != nil {
+		err = fmt.Errorf("invalid compressed message: %w", err)
+	}
+	return n, err
+}
+
+// tailStripWriter strips the trailing 0x00 0x00 0xff 0xff of a sync flush from the stream (RFC 7692 section 7.2.1).
+type tailStripWriter struct {
+	w    io.Writer
+	tail [deflateSyncTailSize]byte
+	n    int
+}
+
+func (t *tailStripWriter) Write(p []byte) (int, error) {
+	total := len(p)
+	if t.n < len(t.tail) {
+		copied := copy(t.tail[t.n:], p)
+		t.n += copied
+		p = p[copied:]
+		if len(p) == 0 {
+			return total, nil
+		}
+	}
+	if len(p) >= len(t.tail) {
+		if _, err := t.w.Write(t.tail[:]); err != nil {
+			return 0, err
+		}
+		if _, err := t.w.Write(p[:len(p)-len(t.tail)]); err != nil {
+			return 0, err
+		}
+		copy(t.tail[:], p[len(p)-len(t.tail):])
+		return total, nil
+	}
+	if _, err := t.w.Write(t.tail[:len(p)]); err != nil {
+		return 0, err
+	}
+	copy(t.tail[:], t.tail[len(p):])
+	copy(t.tail[len(t.tail)-len(p):], p)
+	return total, nil
+}
+
+// complete returns true if the held back bytes are the sync flush tail, and resets the writer for the next message.
+func (t *tailStripWriter) complete() bool {
+	ok := t.n == len(t.tail) && t.tail == [deflateSyncTailSize]byte{0x00, 0x00, 0xff, 0xff}
+	t.n = 0
+	return ok
+}
+
+// nopWriteCloser adds a no-op Close to an io.Writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
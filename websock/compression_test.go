@@ -0,0 +1,199 @@
+package websock
+
+import (
+	"bytes"
+	"compress/flate"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAcceptDeflateOffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     CompressionOptions
+		offer    ExtensionParams
+		want     ExtensionParams
+		rejected bool
+	}{
+		{name: "plain", offer: ExtensionParams{}, want: ExtensionParams{}},
+		{
+			name:  "no context takeover",
+			offer: ExtensionParams{serverNoContextParam: "", clientNoContextParam: ""},
+			want:  ExtensionParams{serverNoContextParam: "", clientNoContextParam: ""},
+		},
+		{
+			name:  "server option",
+			opts:  CompressionOptions{ServerNoContextTakeover: true},
+			offer: ExtensionParams{},
+			want:  ExtensionParams{serverNoContextParam: ""},
+		},
+		{
+			name:  "client window",
+			opts:  CompressionOptions{ClientMaxWindowBits: 10},
+			offer: ExtensionParams{clientMaxWindowParam: ""},
+			want:  ExtensionParams{clientMaxWindowParam: "10"},
+		},
+		{
+			name:  "client window offered",
+			offer: ExtensionParams{clientMaxWindowParam: "9"},
+			want:  ExtensionParams{clientMaxWindowParam: "9"},
+		},
+		{
+			name:  "server window 15",
+			offer: ExtensionParams{serverMaxWindowParam: "15"},
+			want:  ExtensionParams{serverMaxWindowParam: "15"},
+		},
+		{name: "server window 10", offer: ExtensionParams{serverMaxWindowParam: "10"}, rejected: true},
+		{name: "bad window", offer: ExtensionParams{clientMaxWindowParam: "08"}, rejected: true},
+		{name: "takeover value", offer: ExtensionParams{serverNoContextParam: "1"}, rejected: true},
+		{name: "unknown", offer: ExtensionParams{"foo": ""}, rejected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, _, ok := acceptDeflateOffer(&tt.opts, tt.offer)
+			if ok == tt.rejected {
+				t.Fatalf("acceptDeflateOffer() ok = %v, want %v", ok, !tt.rejected)
+			}
+			if ok && !maps.Equal(response, tt.want) {
+				t.Errorf("acceptDeflateOffer() response = %v, want %v", response, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeflateConfirm(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     CompressionOptions
+		response ExtensionParams
+		wantErr  bool
+	}{
+		{name: "plain", response: ExtensionParams{}},
+		{name: "no context takeover", response: ExtensionParams{serverNoContextParam: "", clientNoContextParam: ""}},
+		{name: "server window not offered", response: ExtensionParams{serverMaxWindowParam: "10"}},
+		{name: "server window without value", response: ExtensionParams{serverMaxWindowParam: ""}, wantErr: true},
+		{name: "server window too large", response: ExtensionParams{serverMaxWindowParam: "16"}, wantErr: true},
+		{name: "client window not offered", response: ExtensionParams{clientMaxWindowParam: "10"}, wantErr: true},
+		{
+			name:     "missing server no context takeover",
+			opts:     CompressionOptions{ServerNoContextTakeover: true},
+			response: ExtensionParams{},
+			wantErr:  true,
+		},
+		{name: "unknown", response: ExtensionParams{"foo": ""}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DeflateExtension(&tt.opts).(ClientExtension).Confirm(tt.response)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Confirm() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompressedMessages(t *testing.T) {
+	tests := []struct {
+		name   string
+		server *CompressionOptions
+		client *CompressionOptions
+	}{
+		{name: "context takeover", server: &CompressionOptions{}, client: &CompressionOptions{}},
+		{
+			name:   "no context takeover",
+			server: &CompressionOptions{ServerNoContextTakeover: true},
+			client: &CompressionOptions{ClientNoContextTakeover: true, Level: flate.BestSpeed},
+		},
+		{name: "client only", client: &CompressionOptions{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := serve(t, &Upgrader{Compression: tt.server}, echo)
+			ws := dial(t, &Dialer{Compression: tt.client}, url)
+			if enabled := ws.extensionsHeader != ""; enabled != (tt.server != nil) {
+				t.Fatalf("extensions header = %q", ws.extensionsHeader)
+			}
+			text := strings.Repeat(`{"user":"gopher","status":"online"}`, 50)
+			binary := bytes.Repeat([]byte{1, 2, 3, 4, 5}, 1000)
+			for range 3 {
+				if err := ws.WriteTextMessage(text); err != nil {
+					t.Fatal(err)
+				}
+				if messageType, data, err := ws.ReadMessage(); err != nil || messageType != frames.OpText ||
+					string(data) != text {
+					t.Fatalf("ReadMessage() = %v, %d bytes, %v", messageType, len(data), err)
+				}
+				if err := ws.WriteFragmentedMessage(binary, 700, frames.OpBinary); err != nil {
+					t.Fatal(err)
+				}
+				if messageType, data, err := ws.ReadMessage(); err != nil || messageType != frames.OpBinary ||
+					!bytes.Equal(data, binary) {
+					t.Fatalf("ReadMessage() = %v, %d bytes, %v", messageType, len(data), err)
+				}
+			}
+		})
+	}
+}
+
+// TestDeflateWire checks the compressed frames against compress/flate, with the context taken over between messages.
+func TestDeflateWire(t *testing.T) {
+	url := serve(t, &Upgrader{Compression: &CompressionOptions{}}, echo)
+	conn, br, resp := upgradeRaw(t, url, http.Header{
+		"Sec-WebSocket-Extensions": {"x-unknown, permessage-deflate; server_max_window_bits=10, permessage-deflate"},
+	})
+	if got := resp.Header.Get("Sec-WebSocket-Extensions"); got != permessageDeflate {
+		t.Fatalf("Sec-WebSocket-Extensions = %q, want %q", got, permessageDeflate)
+	}
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history []byte
+	for _, message := range []string{"hello hello hello hello", "hello hello hello hello again", ""} {
+		compressed.Reset()
+		_, _ = fw.Write([]byte(message))
+		_ = fw.Flush()
+		frame, err := frames.NewClientFrame(true, frames.OpText, bytes.TrimSuffix(compressed.Bytes(), deflateReadTail[:4]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame.Rsv1 = true
+		encoded, _ := frame.MarshalBinary()
+		if _, err = conn.Write(encoded); err != nil {
+			t.Fatal(err)
+		}
+
+		reply, err := frames.DecodeFrame(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reply.Rsv1 || !reply.Fin || reply.Masked {
+			t.Fatalf("reply frame Rsv1 = %v, Fin = %v, Masked = %v", reply.Rsv1, reply.Fin, reply.Masked)
+		}
+		fr := flate.NewReaderDict(io.MultiReader(bytes.NewReader(reply.PayloadData), bytes.NewReader(deflateReadTail)),
+			history)
+		data, err := io.ReadAll(fr)
+		if err != nil || string(data) != message {
+			t.Fatalf("decompressed reply = %q, %v, want %q", data, err, message)
+		}
+		history = append(history, data...)
+	}
+}
+
+// echo sends every data message back until the connection ends.
+func echo(ws *WebSocket, _ *http.Request) {
+	for {
+		messageType, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err = writeMessage(ws, messageType, data); err != nil {
+			return
+		}
+	}
+}
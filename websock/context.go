@@ -0,0 +1,95 @@
+package websock
+
+import (
+	"context"
+	"fmt"
+	"github.com/blazskufca/gowebsock/frames"
+	"time"
+)
+
+// ReadMessageContext reads a complete message like ReadMessage, returning ctx.Err() once ctx is done. The deadline of
+// ctx is applied as the read deadline of the connection, and the read deadline is cleared when ReadMessageContext
+// returns. If ctx is done before the next message starts to arrive, the connection remains usable. If it is done while
+// a message is read, the rest of the message is discarded by the next read, and the connection is only closed if ctx
+// was done in the middle of a frame header or of a compressed message.
+func (ws *WebSocket) ReadMessageContext(ctx context.Context) (frames.Opcode, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := ws.Conn.SetReadDeadline(deadline); err != nil {
+		return 0, nil, err
+	}
+	stop := interruptOnDone(ctx, ws.Conn.SetReadDeadline)
+	messageType, data, err := ws.ReadMessage()
+	stop()
+	if resetErr := ws.Conn.SetReadDeadline(time.Time{}); err == nil {
+		err = resetErr
+	}
+	if ctxErr := contextErr(ctx); isTimeout(err) && ctxErr != nil {
+		return 0, nil, ctxErr
+	}
+	return messageType, data, err
+}
+
+// WriteMessageContext sends a text or binary message like WriteTextMessage and WriteBinaryMessage, returning
+// ctx.Err() once ctx is done. The deadline of ctx is applied as the write deadline of the connection, and the write
+// deadline is cleared when WriteMessageContext returns. A message which could not be written completely leaves the
+// frame stream broken, so if ctx is done before the message is written, the connection is closed.
+func (ws *WebSocket) WriteMessageContext(ctx context.Context, messageType frames.Opcode, data []byte) error {
+	if messageType != frames.OpText && messageType != frames.OpBinary {
+		return fmt.Errorf("invalid message type %v", messageType)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ws.messageMu.Lock()
+	defer ws.messageMu.Unlock()
+	deadline, _ := ctx.Deadline()
+	if err := ws.Conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	stop := interruptOnDone(ctx, ws.Conn.SetWriteDeadline)
+	var err error
+	if messageType == frames.OpText {
+		err = ws.writeText(string(data))
+	} else {
+		err = ws.writeBinary(data)
+	}
+	stop()
+	if resetErr := ws.Conn.SetWriteDeadline(time.Time{}); err == nil {
+		err = resetErr
+	}
+	if ctxErr := contextErr(ctx); err != nil && ctxErr != nil {
+		ws.setCloseErr(ctxErr)
+		_ = ws.closeConn()
+		return ctxErr
+	}
+	return err
+}
+
+// interruptOnDone sets the deadline with set to a time in the past once ctx is done, which interrupts a blocked read
+// or write. The returned function stops watching ctx and waits for an interruption in progress.
+func interruptOnDone(ctx context.Context, set func(t time.Time) error) (stop func()) {
+	done := make(chan struct{})
+	stopAfter := context.AfterFunc(ctx, func() {
+		defer close(done)
+		_ = set(time.Unix(1, 0))
+	})
+	return func() {
+		if !stopAfter() {
+			<-done
+		}
+	}
+}
+
+// contextErr returns the error of ctx, or context.DeadlineExceeded if the deadline of ctx passed before ctx noticed.
+func contextErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
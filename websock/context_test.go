@@ -0,0 +1,145 @@
+package websock
+
+import (
+	"context"
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReadMessageContextBetweenMessages(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan string, 1)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		<-release
+		_ = ws.WriteTextMessage("late")
+		if _, data, err := ws.ReadMessage(); err == nil {
+			received <- string(data)
+		}
+	})
+	ws := dial(t, &Dialer{}, url)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := ws.ReadMessageContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ReadMessageContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	ctx, cancel = context.WithCancel(t.Context())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, _, err := ws.ReadMessageContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReadMessageContext() error = %v, want %v", err, context.Canceled)
+	}
+	if _, _, err := ws.ReadMessageContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReadMessageContext() with a done context error = %v, want %v", err, context.Canceled)
+	}
+
+	// Neither expiry interrupted a message, so the connection is still usable.
+	close(release)
+	if _, data, err := ws.ReadMessageContext(t.Context()); err != nil || string(data) != "late" {
+		t.Fatalf("ReadMessageContext() = %q, %v", data, err)
+	}
+	if err := ws.WriteMessageContext(t.Context(), frames.OpText, []byte("ok")); err != nil {
+		t.Fatalf("WriteMessageContext() error = %v", err)
+	}
+	if got := <-received; got != "ok" {
+		t.Errorf("server received %q, want %q", got, "ok")
+	}
+}
+
+func TestReadMessageContextMidMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		// partial is the start of a message which is sent before the context expires.
+		partial []byte
+		// rest completes the message, if it can be completed after the context expired.
+		rest     []byte
+		wantCode frames.WebSocketStatusCode
+	}{
+		{
+			name:    "payload",
+			partial: []byte{0x82, 0x7e, 0x00, 0x06, 1, 2, 3},
+			rest:    []byte{4, 5, 6},
+		},
+		{
+			// The expiry is not the peer's fault, so it is not reported as a protocol error.
+			name:     "header",
+			partial:  []byte{0x82, 0x7e, 0x00},
+			wantCode: frames.GoingAway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expired := make(chan struct{})
+			closeCode := make(chan frames.WebSocketStatusCode, 1)
+			url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+				_, _ = ws.Conn.Write(tt.partial)
+				<-expired
+				if tt.rest != nil {
+					_, _ = ws.Conn.Write(tt.rest)
+					_ = ws.WriteTextMessage("next")
+				}
+				_, _, err := ws.ReadMessage()
+				var closeErr *CloseError
+				if !errors.As(err, &closeErr) {
+					closeCode <- 0
+					return
+				}
+				closeCode <- closeErr.Code
+			})
+			ws := dial(t, &Dialer{}, url)
+			ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+			defer cancel()
+			_, _, err := ws.ReadMessageContext(ctx)
+			close(expired)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("ReadMessageContext() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if tt.wantCode != 0 {
+				if code := <-closeCode; code != tt.wantCode {
+					t.Errorf("close status = %v, want %v", code, tt.wantCode)
+				}
+				if _, _, err = ws.ReadMessage(); err == nil {
+					t.Error("ReadMessage() after a broken frame header error = nil")
+				}
+				return
+			}
+			// The rest of the interrupted message is discarded and the connection is still usable.
+			if _, data, err := ws.ReadMessage(); err != nil || string(data) != "next" {
+				t.Fatalf("ReadMessage() after the expiry = %q, %v, want %q", data, err, "next")
+			}
+			if err = ws.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+			if code := <-closeCode; code != frames.NormalClosure {
+				t.Errorf("close status = %v, want %v", code, frames.NormalClosure)
+			}
+		})
+	}
+}
+
+func TestWriteMessageContext(t *testing.T) {
+	release := make(chan struct{})
+	// The server never reads, so a large message fills the socket buffers.
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		<-release
+	})
+	t.Cleanup(func() { close(release) })
+	ws := dial(t, &Dialer{}, url)
+	if err := ws.WriteMessageContext(t.Context(), frames.OpPing, nil); err == nil {
+		t.Error("WriteMessageContext(OpPing) error = nil")
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if err := ws.WriteMessageContext(ctx, frames.OpBinary, make([]byte, 32<<20)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WriteMessageContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// A partially written message breaks the frame stream, so the connection is closed.
+	if err := ws.WriteTextMessage("after"); err == nil {
+		t.Error("WriteTextMessage() after an interrupted write error = nil")
+	}
+	if err := ws.closedErr(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("connection ended with %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,330 @@
+package websock
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ReservedBits is a set of the RSV1, RSV2 and RSV3 bits of a frame header.
+type ReservedBits uint8
+
+const (
+	// RSV1 is the Frame.Rsv1 bit.
+	RSV1 ReservedBits = 1 << iota
+	// RSV2 is the Frame.Rsv2 bit.
+	RSV2
+	// RSV3 is the Frame.Rsv3 bit.
+	RSV3
+)
+
+// frameReservedBits returns the reserved bits set on a frame.
+func frameReservedBits(fr *frames.Frame) ReservedBits {
+	var bits ReservedBits
+	if fr.Rsv1 {
+		bits |= RSV1
+	}
+	if fr.Rsv2 {
+		bits |= RSV2
+	}
+	if fr.Rsv3 {
+		bits |= RSV3
+	}
+	return bits
+}
+
+// setFrameReservedBits sets the given reserved bits on a frame.
+func setFrameReservedBits(fr *frames.Frame, bits ReservedBits) {
+	fr.Rsv1 = fr.Rsv1 || bits&RSV1 != 0
+	fr.Rsv2 = fr.Rsv2 || bits&RSV2 != 0
+	fr.Rsv3 = fr.Rsv3 || bits&RSV3 != 0
+}
+
+// ExtensionParams are the parameters of a single extension in a Sec-WebSocket-Extensions header. Parameters without
+// a value map to an empty string.
+type ExtensionParams map[string]string
+
+// Extension is a WebSocket extension (RFC 6455 section 9) which the server can negotiate in the opening handshake.
+type Extension interface {
+	// Name returns the extension token as it appears in the Sec-WebSocket-Extensions header.
+	Name() string
+	// Accept is called for each client offer of the extension, in the order of client preference, until an offer is
+	// accepted. It returns the parameters of the response and the state of the extension for the new connection, or
+	// ok false to decline the offer.
+	Accept(offer ExtensionParams) (response ExtensionParams, conn ExtensionConn, ok bool)
+}
+
+// ClientExtension is a WebSocket extension which a client can offer in the opening handshake.
+type ClientExtension interface {
+	// Name returns the extension token as it appears in the Sec-WebSocket-Extensions header.
+	Name() string
+	// Offer returns the parameters of the client offer.
+	Offer() ExtensionParams
+	// Confirm is called with the parameters of the server response when the server accepted the offer. It returns the
+	// state of the extension for the new connection, or an error which fails the handshake.
+	Confirm(response ExtensionParams) (ExtensionConn, error)
+}
+
+// ExtensionConn is a negotiated Extension on a single connection. Negotiated extensions form a chain in the order of
+// the handshake response: outgoing messages pass through the first extension first and incoming messages through the
+// last extension first.
+type ExtensionConn interface {
+	// ReservedBits returns the frame RSV bits the extension is allowed to use. Frames with RSV bits which are not
+	// claimed by any negotiated extension fail the connection.
+	ReservedBits() ReservedBits
+	// ReadMessage wraps the payload reader of an incoming data message. rsv holds the RSV bits of its first frame.
+	ReadMessage(opcode frames.Opcode, rsv ReservedBits, r io.Reader) (io.Reader, error)
+	// WriteMessage wraps the payload writer of an outgoing data message. The returned writer must close w when it is
+	// closed. The returned RSV bits are set on the first frame of the message.
+	WriteMessage(opcode frames.Opcode, w io.WriteCloser) (io.WriteCloser, ReservedBits, error)
+}
+
+// FrameExtension is implemented by an ExtensionConn which inspects or transforms individual frames. ReadFrame is
+// called with every validated incoming frame and WriteFrame with every outgoing frame before it is encoded. The payload
+// of incoming data frames is streamed, so ReadFrame only sees their header. An error returned by ReadFrame fails the
+// connection with frames.ProtocolError.
+type FrameExtension interface {
+	ReadFrame(fr *frames.Frame) error
+	WriteFrame(fr *frames.Frame) error
+}
+
+// extensionChain is the ordered list of extensions negotiated on a connection.
+type extensionChain []ExtensionConn
+
+// reservedBits returns the RSV bits claimed by the extensions of the chain.
+func (c extensionChain) reservedBits() ReservedBits {
+	var bits ReservedBits
+	for _, ext := range c {
+		bits |= ext.ReservedBits()
+	}
+	return bits
+}
+
+// readFrame passes an incoming frame through the frame extensions of the chain, last first.
+func (c extensionChain) readFrame(fr *frames.Frame) error {
+	for i := len(c) - 1; i >= 0; i-- {
+		if ext, ok := c[i].(FrameExtension); ok {
+			if err := ext.ReadFrame(fr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFrame passes an outgoing frame through the frame extensions of the chain, first first.
+func (c extensionChain) writeFrame(fr *frames.Frame) error {
+	for _, ext := range c {
+		if ext, ok := ext.(FrameExtension); ok {
+			if err := ext.WriteFrame(fr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reader returns the reader of an incoming message payload, decoded by the chain.
+func (c extensionChain) reader(opcode frames.Opcode, rsv ReservedBits, r io.Reader) (io.Reader, error) {
+	for i := len(c) - 1; i >= 0; i-- {
+		next, err := c[i].ReadMessage(opcode, rsv, r)
+		if err != nil {
+			return nil, err
+		}
+		r = next
+	}
+	return r, nil
+}
+
+// writer returns the writer of an outgoing message payload, encoded by the chain, and the RSV bits of the message.
+func (c extensionChain) writer(opcode frames.Opcode, w io.WriteCloser) (io.WriteCloser, ReservedBits, error) {
+	var rsv ReservedBits
+	for i := len(c) - 1; i >= 0; i-- {
+		next, bits, err := c[i].WriteMessage(opcode, w)
+		if err != nil {
+			return nil, 0, err
+		}
+		w = next
+		rsv |= bits
+	}
+	return w, rsv, nil
+}
+
+// encode returns the payload of an outgoing message encoded by the chain and the RSV bits of the message.
+func (c extensionChain) encode(opcode frames.Opcode, payload []byte) ([]byte, ReservedBits, error) {
+	var buf bytes.Buffer
+	w, rsv, err := c.writer(opcode, nopWriteCloser{&buf})
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err = w.Write(payload); err != nil {
+		_ = w.Close()
+		return nil, 0, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), rsv, nil
+}
+
+// negotiateExtensions accepts the client extension offers in order of preference. An offer is skipped if its extension
+// is not supported, was already accepted, or claims RSV bits of an accepted extension. It returns the chain and the
+// value of the Sec-WebSocket-Extensions response header.
+func negotiateExtensions(supported []Extension, header http.Header) (extensionChain, string) {
+	var chain extensionChain
+	var accepted []string
+	var response []string
+	var claimed ReservedBits
+	for _, offer := range parseExtensions(header) {
+		if slices.Contains(accepted, offer.name) {
+			continue
+		}
+		for _, ext := range supported {
+			if ext.Name() != offer.name {
+				continue
+			}
+			params, conn, ok := ext.Accept(offer.params)
+			if !ok || conn.ReservedBits()&claimed != 0 {
+				continue
+			}
+			chain = append(chain, conn)
+			accepted = append(accepted, offer.name)
+			response = append(response, formatExtension(offer.name, params))
+			claimed |= conn.ReservedBits()
+			break
+		}
+	}
+	return chain, strings.Join(response, ", ")
+}
+
+// offerExtensions returns the value of the Sec-WebSocket-Extensions request header offering the given extensions.
+func offerExtensions(extensions []ClientExtension) string {
+	offers := make([]string, 0, len(extensions))
+	for _, ext := range extensions {
+		offers = append(offers, formatExtension(ext.Name(), ext.Offer()))
+	}
+	return strings.Join(offers, ", ")
+}
+
+// confirmExtensions builds the extension chain from the Sec-WebSocket-Extensions response header. The server may only
+// accept each offered extension once, and the accepted extensions must not claim the same RSV bits.
+func confirmExtensions(offered []ClientExtension, header http.Header) (extensionChain, error) {
+	var chain extensionChain
+	var accepted []string
+	var claimed ReservedBits
+	for _, response := range parseExtensions(header) {
+		index := slices.IndexFunc(offered, func(ext ClientExtension) bool { return ext.Name() == response.name })
+		if index < 0 || slices.Contains(accepted, response.name) {
+			return nil, fmt.Errorf("server accepted extension %q which was not offered", response.name)
+		}
+		conn, err := offered[index].Confirm(response.params)
+		if err != nil {
+			return nil, fmt.Errorf("extension %q: %w", response.name, err)
+		}
+		if conn.ReservedBits()&claimed != 0 {
+			return nil, fmt.Errorf("extension %q claims RSV bits of another extension", response.name)
+		}
+		chain = append(chain, conn)
+		accepted = append(accepted, response.name)
+		claimed |= conn.ReservedBits()
+	}
+	return chain, nil
+}
+
+// extensionOffer is a single element of a Sec-WebSocket-Extensions header.
+type extensionOffer struct {
+	name   string
+	params ExtensionParams
+}
+
+// parseExtensions parses all the Sec-WebSocket-Extensions headers. Elements with duplicate or empty parameter
+// names are dropped, as such offers have to be declined.
+func parseExtensions(header http.Header) []extensionOffer {
+	var offers []extensionOffer
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+		for _, element := range splitQuoted(value, ',') {
+			parts := splitQuoted(element, ';')
+			offer := extensionOffer{name: strings.TrimSpace(parts[0]), params: make(ExtensionParams)}
+			if offer.name == "" {
+				continue
+			}
+			valid := true
+			for _, part := range parts[1:] {
+				name, value, _ := strings.Cut(part, "=")
+				name = strings.TrimSpace(name)
+				value = strings.TrimSpace(value)
+				if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+					value = unquoted
+				}
+				if _, duplicate := offer.params[name]; duplicate || name == "" {
+					valid = false
+					break
+				}
+				offer.params[name] = value
+			}
+			if valid {
+				offers = append(offers, offer)
+			}
+		}
+	}
+	return offers
+}
+
+// formatExtension formats an extension and its parameters as a Sec-WebSocket-Extensions element.
+func formatExtension(name string, params ExtensionParams) string {
+	element := []string{name}
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		switch value := params[key]; {
+		case value == "":
+			element = append(element, key)
+		case isToken(value):
+			element = append(element, key+"="+value)
+		default:
+			element = append(element, key+"="+strconv.Quote(value))
+		}
+	}
+	return strings.Join(element, "; ")
+}
+
+// isToken returns true if s is a non-empty RFC 7230 token.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, s[i]) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// splitQuoted splits s around sep, ignoring separators within quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
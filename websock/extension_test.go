@@ -0,0 +1,195 @@
+package websock
+
+import (
+	"bytes"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// flipExtension is a test extension which flips the case of ASCII letters in messages marked with RSV2.
+type flipExtension struct {
+	name string
+	rsv  ReservedBits
+	// messages counts the messages decoded by the extension on every connection.
+	messages *atomic.Int32
+}
+
+func (e flipExtension) Name() string {
+	return e.name
+}
+
+func (e flipExtension) Accept(offer ExtensionParams) (ExtensionParams, ExtensionConn, bool) {
+	if _, ok := offer["decline"]; ok {
+		return nil, nil, false
+	}
+	return ExtensionParams{"mode": "flip case"}, flipConn(e), true
+}
+
+func (e flipExtension) Offer() ExtensionParams {
+	return ExtensionParams{}
+}
+
+func (e flipExtension) Confirm(response ExtensionParams) (ExtensionConn, error) {
+	return flipConn(e), nil
+}
+
+type flipConn flipExtension
+
+func (c flipConn) ReservedBits() ReservedBits {
+	return c.rsv
+}
+
+func (c flipConn) ReadMessage(_ frames.Opcode, rsv ReservedBits, r io.Reader) (io.Reader, error) {
+	if rsv&c.rsv == 0 {
+		return r, nil
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c.messages.Add(1)
+	return bytes.NewReader(flipCase(data)), nil
+}
+
+func (c flipConn) WriteMessage(_ frames.Opcode, w io.WriteCloser) (io.WriteCloser, ReservedBits, error) {
+	return &flipWriter{w: w}, c.rsv, nil
+}
+
+// flipWriter flips the case of the message written through it.
+type flipWriter struct {
+	w io.WriteCloser
+}
+
+func (w *flipWriter) Write(p []byte) (int, error) {
+	return w.w.Write(flipCase(bytes.Clone(p)))
+}
+
+func (w *flipWriter) Close() error {
+	return w.w.Close()
+}
+
+// flipCase flips the case of the ASCII letters in b.
+func flipCase(b []byte) []byte {
+	for i, c := range b {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			b[i] = c ^ 0x20
+		}
+	}
+	return b
+}
+
+func TestExtensionChain(t *testing.T) {
+	var serverMessages, clientMessages atomic.Int32
+	u := &Upgrader{
+		Compression: &CompressionOptions{},
+		Extensions:  []Extension{flipExtension{name: "x-flip", rsv: RSV2, messages: &serverMessages}},
+	}
+	url := serve(t, u, echo)
+	d := &Dialer{
+		Compression: &CompressionOptions{},
+		Extensions:  []ClientExtension{flipExtension{name: "x-flip", rsv: RSV2, messages: &clientMessages}},
+	}
+	ws, resp, err := d.Dial(t.Context(), url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.closeConn()
+	want := `permessage-deflate, x-flip; mode="flip case"`
+	if got := resp.Header.Get("Sec-WebSocket-Extensions"); got != want {
+		t.Fatalf("Sec-WebSocket-Extensions = %q, want %q", got, want)
+	}
+	for _, message := range []string{"Hello, Extensions!", "second MESSAGE"} {
+		if err = ws.WriteTextMessage(message); err != nil {
+			t.Fatal(err)
+		}
+		if _, data, err := ws.ReadMessage(); err != nil || string(data) != message {
+			t.Fatalf("ReadMessage() = %q, %v, want %q", data, err, message)
+		}
+	}
+	if got := serverMessages.Load(); got != 2 {
+		t.Errorf("server extension decoded %d messages, want 2", got)
+	}
+	if got := clientMessages.Load(); got != 2 {
+		t.Errorf("client extension decoded %d messages, want 2", got)
+	}
+}
+
+func TestNegotiateExtensions(t *testing.T) {
+	var messages atomic.Int32
+	supported := []Extension{
+		DeflateExtension(&CompressionOptions{}),
+		flipExtension{name: "x-flip", rsv: RSV2, messages: &messages},
+		flipExtension{name: "x-conflict", rsv: RSV1, messages: &messages},
+	}
+	tests := []struct {
+		offer string
+		want  string
+	}{
+		{offer: "x-flip; decline, x-flip", want: `x-flip; mode="flip case"`},
+		{offer: "x-flip, x-flip", want: `x-flip; mode="flip case"`},
+		{offer: "permessage-deflate, x-conflict", want: "permessage-deflate"},
+		{offer: "x-conflict, permessage-deflate", want: `x-conflict; mode="flip case"`},
+		{offer: "x-unknown; a=1, x-flip; a; a", want: ""},
+	}
+	for _, tt := range tests {
+		chain, got := negotiateExtensions(supported, http.Header{"Sec-Websocket-Extensions": {tt.offer}})
+		if got != tt.want {
+			t.Errorf("negotiateExtensions(%q) = %q, want %q", tt.offer, got, tt.want)
+		}
+		if len(chain) != len(parseExtensions(http.Header{"Sec-Websocket-Extensions": {got}})) {
+			t.Errorf("negotiateExtensions(%q) chain has %d extensions", tt.offer, len(chain))
+		}
+	}
+}
+
+func TestParseExtensions(t *testing.T) {
+	header := http.Header{"Sec-Websocket-Extensions": {`foo; a=1; b="x, y", bar`, "baz; a; a"}}
+	want := []extensionOffer{
+		{name: "foo", params: ExtensionParams{"a": "1", "b": "x, y"}},
+		{name: "bar", params: ExtensionParams{}},
+	}
+	if got := parseExtensions(header); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseExtensions() = %v, want %v", got, want)
+	}
+}
+
+func TestUnclaimedReservedBits(t *testing.T) {
+	var messages atomic.Int32
+	readErr := make(chan error, 1)
+	u := &Upgrader{Extensions: []Extension{flipExtension{name: "x-flip", rsv: RSV2, messages: &messages}}}
+	url := serve(t, u, func(ws *WebSocket, r *http.Request) {
+		_, data, err := ws.ReadMessage()
+		if err == nil && string(data) != "hello" {
+			t.Errorf("first message = %q, want %q", data, "hello")
+		}
+		_, _, err = ws.ReadMessage()
+		readErr <- err
+	})
+	conn, br, _ := upgradeRaw(t, url, http.Header{"Sec-WebSocket-Extensions": {"x-flip"}})
+	for _, rsv3 := range []bool{false, true} {
+		frame, err := frames.NewClientFrame(true, frames.OpText, []byte("HELLO"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame.Rsv2 = true
+		frame.Rsv3 = rsv3
+		encoded, _ := frame.MarshalBinary()
+		if _, err = conn.Write(encoded); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-readErr; err == nil {
+		t.Fatal("ReadMessage() error = nil, want an error for RSV3")
+	}
+	frame, err := frames.DecodeFrame(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _, _ := frame.ReadCloseFrame(); frame.OpCode != frames.OpClose || code != frames.ProtocolError {
+		t.Errorf("got %v frame with status %v, want a close frame with %v", frame.OpCode, code, frames.ProtocolError)
+	}
+}
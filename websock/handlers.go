@@ -0,0 +1,84 @@
+package websock
+
+import (
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+)
+
+// SetPingHandler sets the handler called with the application data of every ping received while the connection is
+// read. The default handler answers with a pong carrying the same data. A nil handler restores the default. An error
+// returned by the handler fails the connection and is returned by the read.
+func (ws *WebSocket) SetPingHandler(h func(appData string) error) {
+	ws.pingHandler = h
+}
+
+// PingHandler returns the current ping handler, so a custom handler can wrap the default one.
+func (ws *WebSocket) PingHandler() func(appData string) error {
+	if ws.pingHandler == nil {
+		return ws.defaultPingHandler
+	}
+	return ws.pingHandler
+}
+
+// SetPongHandler sets the handler called with the application data of every pong received while the connection is
+// read. The default handler does nothing, and keepalive round-trip times are measured regardless of the handler. A nil
+// handler restores the default. An error returned by the handler fails the connection and is returned by the read.
+func (ws *WebSocket) SetPongHandler(h func(appData string) error) {
+	ws.pongHandler = h
+}
+
+// PongHandler returns the current pong handler, so a custom handler can wrap the default one.
+func (ws *WebSocket) PongHandler() func(appData string) error {
+	if ws.pongHandler == nil {
+		return ws.defaultPongHandler
+	}
+	return ws.pongHandler
+}
+
+// SetCloseHandler sets the handler called with the status code and reason of a close frame received while the
+// connection is read. The default handler echoes the close frame. A custom handler is responsible for answering with
+// a close frame; the connection is closed once it returns. A nil handler restores the default. An error returned by
+// the handler is returned by the read.
+func (ws *WebSocket) SetCloseHandler(h func(code frames.WebSocketStatusCode, reason string) error) {
+	ws.closeHandler = h
+}
+
+// CloseHandler returns the current close handler, so a custom handler can wrap the default one.
+func (ws *WebSocket) CloseHandler() func(code frames.WebSocketStatusCode, reason string) error {
+	if ws.closeHandler == nil {
+		return ws.defaultCloseHandler
+	}
+	return ws.closeHandler
+}
+
+// defaultPingHandler answers a ping with a pong carrying the same application data. Pings received after a close
+// frame was sent are not answered.
+func (ws *WebSocket) defaultPingHandler(appData string) error {
+	err := ws.WritePongMessage(&frames.Frame{OpCode: frames.OpPing, PayloadData: []byte(appData)})
+	if errors.Is(err, ErrConnectionClosing) {
+		return nil
+	}
+	return err
+}
+
+// defaultPongHandler ignores pongs.
+func (ws *WebSocket) defaultPongHandler(string) error {
+	return nil
+}
+
+// defaultCloseHandler echoes a close frame.
+func (ws *WebSocket) defaultCloseHandler(code frames.WebSocketStatusCode, reason string) error {
+	return ws.WriteCloseMessage(code, reason)
+}
+
+// controlData returns the application data of a ping or pong frame. Data which is not valid UTF-8 is passed as is.
+func controlData(frame *frames.Frame) string {
+	read := frame.ReadPingFrame
+	if frame.OpCode == frames.OpPong {
+		read = frame.ReadPongFrame
+	}
+	if appData, err := read(); err == nil {
+		return appData
+	}
+	return string(frame.PayloadData)
+}
@@ -0,0 +1,105 @@
+package websock
+
+import (
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestPingPongHandlers(t *testing.T) {
+	pings := make(chan string, 2)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		pong := ws.PingHandler()
+		ws.SetPingHandler(func(appData string) error {
+			pings <- appData
+			return pong(appData)
+		})
+		_, _, _ = ws.ReadMessage()
+	})
+	ws := dial(t, &Dialer{}, url)
+	pongs := make(chan string, 2)
+	ws.SetPongHandler(func(appData string) error {
+		pongs <- appData
+		return nil
+	})
+	go func() {
+		_, _, _ = ws.ReadMessage()
+	}()
+	if err := ws.WritePingMessage("hb-1"); err != nil {
+		t.Fatal(err)
+	}
+	// Application data which is not valid UTF-8 is passed as is.
+	binary, _ := frames.NewClientFrame(true, frames.OpPing, []byte{0xff, 1})
+	if err := ws.WriteFrames([]*frames.Frame{binary}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"hb-1", "\xff\x01"}
+	if got := []string{<-pings, <-pings}; !slices.Equal(got, want) {
+		t.Errorf("server pings = %q, want %q", got, want)
+	}
+	if got := []string{<-pongs, <-pongs}; !slices.Equal(got, want) {
+		t.Errorf("client pongs = %q, want %q", got, want)
+	}
+}
+
+func TestHandlerErrorFailsRead(t *testing.T) {
+	errRejected := errors.New("ping rejected")
+	readErr := make(chan error, 1)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		ws.SetPingHandler(func(string) error { return errRejected })
+		_, _, err := ws.ReadMessage()
+		readErr <- err
+	})
+	ws := dial(t, &Dialer{}, url)
+	if err := ws.WritePingMessage("ping"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-readErr; !errors.Is(err, errRejected) {
+		t.Errorf("ReadMessage() error = %v, want %v", err, errRejected)
+	}
+}
+
+func TestCloseHandler(t *testing.T) {
+	type closeFrame struct {
+		code   frames.WebSocketStatusCode
+		reason string
+	}
+	received := make(chan closeFrame, 1)
+	readErr := make(chan error, 1)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		reply := ws.CloseHandler()
+		ws.SetCloseHandler(func(code frames.WebSocketStatusCode, reason string) error {
+			received <- closeFrame{code, reason}
+			return reply(code, reason)
+		})
+		_, _, err := ws.ReadMessage()
+		readErr <- err
+	})
+	ws := dial(t, &Dialer{}, url)
+	if err := ws.CloseWithCode(frames.GoingAway, "bye now"); err != nil {
+		t.Fatalf("CloseWithCode() error = %v", err)
+	}
+	if got := <-received; got != (closeFrame{frames.GoingAway, "bye now"}) {
+		t.Errorf("close handler called with %v, want %v %q", got, frames.GoingAway, "bye now")
+	}
+	var closeErr *CloseError
+	if err := <-readErr; !errors.As(err, &closeErr) || closeErr.Code != frames.GoingAway || !closeErr.Remote {
+		t.Errorf("ReadMessage() error = %v, want a close with %v by the peer", err, frames.GoingAway)
+	}
+}
+
+func TestDefaultHandlers(t *testing.T) {
+	ws := &WebSocket{}
+	ws.SetPingHandler(func(string) error { return errors.New("custom") })
+	ws.SetPingHandler(nil)
+	ws.SetPongHandler(nil)
+	ws.SetCloseHandler(nil)
+	if ws.PingHandler() == nil || ws.PongHandler() == nil || ws.CloseHandler() == nil {
+		t.Error("a nil handler did not restore the default")
+	}
+	if err := ws.PongHandler()("pong"); err != nil {
+		t.Errorf("default pong handler error = %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package websock
+
+import (
+	"bytes"
+	"github.com/blazskufca/gowebsock/frames"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHubQueueSize is the number of messages queued per connection when Hub.QueueSize is not set.
+	defaultHubQueueSize int = 16
+	// hubEvictTimeout bounds the closing handshake of a connection removed for being too slow. Its close frame waits
+	// behind the write which is stuck on the connection, so the connection is closed anyway once the timeout expires.
+	hubEvictTimeout time.Duration = 5 * time.Second
+)
+
+// Hub broadcasts messages to a set of connections, which can be grouped in named rooms. Every connection has a queue
+// of outgoing messages, which is written by a goroutine of its own, so a slow connection never stalls a broadcast. A
+// connection whose queue is full is removed from the Hub and closed with frames.ViolatesPolicy. Connections leave
+// the Hub and all its rooms once they are closed. The zero value is ready to use.
+type Hub struct {
+	// QueueSize is the number of messages queued per connection. Zero means 16.
+	QueueSize int
+
+	mu      sync.Mutex
+	members map[*WebSocket]*hubMember
+	rooms   map[string]map[*hubMember]struct{}
+}
+
+// hubMember is a connection added to a Hub.
+type hubMember struct {
+	ws    *WebSocket
+	queue chan *PreparedMessage
+	quit  chan struct{}
+	rooms map[string]struct{}
+}
+
+// Add adds ws to the Hub without joining a room, so it receives messages sent with Broadcast. It returns
+// net.ErrClosed if ws is closed.
+func (h *Hub) Add(ws *WebSocket) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.member(ws)
+	return err
+}
+
+// Join adds ws to room, and to the Hub if it was not added yet.
+func (h *Hub) Join(ws *WebSocket, room string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, err := h.member(ws)
+	if err != nil {
+		return err
+	}
+	if h.rooms == nil {
+		h.rooms = make(map[string]map[*hubMember]struct{})
+	}
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[*hubMember]struct{})
+	}
+	h.rooms[room][m] = struct{}{}
+	m.rooms[room] = struct{}{}
+	return nil
+}
+
+// Leave removes ws from room. The connection stays in the Hub and its other rooms.
+func (h *Hub) Leave(ws *WebSocket, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if m, ok := h.members[ws]; ok {
+		h.leave(m, room)
+	}
+}
+
+// Remove removes ws from the Hub and all its rooms. Queued messages which were not written yet are dropped. The
+// connection is not closed.
+func (h *Hub) Remove(ws *WebSocket) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if m, ok := h.members[ws]; ok {
+		h.remove(m)
+	}
+}
+
+// Len returns the number of connections in the Hub.
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.members)
+}
+
+// RoomLen returns the number of connections in room.
+func (h *Hub) RoomLen(room string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.rooms[room])
+}
+
+// Broadcast queues a text or binary message for every connection in the Hub. It does not wait for the message to be
+// written, and data may be modified once Broadcast returns. The message is prepared once for all connections, see
+// PreparedMessage.
+func (h *Hub) Broadcast(messageType frames.Opcode, data []byte) error {
+	pm, err := NewPreparedMessage(messageType, bytes.Clone(data))
+	if err != nil {
+		return err
+	}
+	h.BroadcastPrepared(pm)
+	return nil
+}
+
+// BroadcastRoom queues a text or binary message for every connection in room. It does not wait for the message to be
+// written, and data may be modified once BroadcastRoom returns. The message is prepared once for all connections, see
+// PreparedMessage.
+func (h *Hub) BroadcastRoom(room string, messageType frames.Opcode, data []byte) error {
+	pm, err := NewPreparedMessage(messageType, bytes.Clone(data))
+	if err != nil {
+		return err
+	}
+	h.BroadcastRoomPrepared(room, pm)
+	return nil
+}
+
+// BroadcastPrepared queues a prepared message for every connection in the Hub.
+func (h *Hub) BroadcastPrepared(pm *PreparedMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, m := range h.members {
+		h.deliver(m, pm)
+	}
+}
+
+// BroadcastRoomPrepared queues a prepared message for every connection in room.
+func (h *Hub) BroadcastRoomPrepared(room string, pm *PreparedMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for m := range h.rooms[room] {
+		h.deliver(m, pm)
+	}
+}
+
+// member returns the member of ws, adding it to the Hub and starting its writer if it is not a member yet. The caller
+// must hold h.mu.
+func (h *Hub) member(ws *WebSocket) (*hubMember, error) {
+	if m, ok := h.members[ws]; ok {
+		return m, nil
+	}
+	queueSize := h.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultHubQueueSize
+	}
+	m := &hubMember{
+		ws:    ws,
+		queue: make(chan *PreparedMessage, queueSize),
+		quit:  make(chan struct{}),
+		rooms: make(map[string]struct{}),
+	}
+	if !ws.onClose(func() { h.Remove(ws) }) {
+		return nil, net.ErrClosed
+	}
+	if h.members == nil {
+		h.members = make(map[*WebSocket]*hubMember)
+	}
+	h.members[ws] = m
+	go h.write(m)
+	return m, nil
+}
+
+// deliver queues pm for m, or removes m and closes its connection if its queue is full. The caller must hold h.mu.
+func (h *Hub) deliver(m *hubMember, pm *PreparedMessage) {
+	select {
+	case m.queue <- pm:
+	default:
+		h.remove(m)
+		go evict(m.ws)
+	}
+}
+
+// evict closes a connection which is too slow to receive broadcast messages, closing the underlying connection if the
+// closing handshake does not finish within hubEvictTimeout.
+func evict(ws *WebSocket) {
+	timer := time.AfterFunc(hubEvictTimeout, func() { _ = ws.closeConn() })
+	defer timer.Stop()
+	_ = ws.CloseWithCode(frames.ViolatesPolicy, "too slow to receive broadcast messages")
+}
+
+// leave removes m from room. The caller must hold h.mu.
+func (h *Hub) leave(m *hubMember, room string) {
+	delete(m.rooms, room)
+	delete(h.rooms[room], m)
+	if len(h.rooms[room]) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// remove removes m from the Hub and all its rooms and stops its writer. The caller must hold h.mu.
+func (h *Hub) remove(m *hubMember) {
+	if h.members[m.ws] != m {
+		return
+	}
+	for room := range m.rooms {
+		h.leave(m, room)
+	}
+	delete(h.members, m.ws)
+	close(m.quit)
+}
+
+// write writes the queued messages of m until it is removed. A failed write removes m from the Hub.
+func (h *Hub) write(m *hubMember) {
+	for {
+		select {
+		case <-m.quit:
+			return
+		case pm := <-m.queue:
+			if err := m.ws.WritePreparedMessage(pm); err != nil {
+				h.mu.Lock()
+				h.remove(m)
+				h.mu.Unlock()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package websock
+
+import (
+	"github.com/blazskufca/gowebsock/frames"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// joinHub returns a handler which adds the connection to hub, joining the room named by the room query parameter if
+// there is one, and reads until the connection is closed.
+func joinHub(t *testing.T, hub *Hub) func(ws *WebSocket, r *http.Request) {
+	return func(ws *WebSocket, r *http.Request) {
+		var err error
+		if room := r.URL.Query().Get("room"); room != "" {
+			err = hub.Join(ws, room)
+		} else {
+			err = hub.Add(ws)
+		}
+		if err != nil {
+			t.Errorf("adding to the hub failed: %v", err)
+			return
+		}
+		for {
+			if _, _, err = ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// waitHub waits until hub has n connections.
+func waitHub(t *testing.T, hub *Hub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.Len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Len() = %d, want %d", hub.Len(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHubBroadcast(t *testing.T) {
+	hub := &Hub{}
+	url := serve(t, &Upgrader{}, joinHub(t, hub))
+	var clients []*WebSocket
+	for _, query := range []string{"?room=a", "?room=a", "?room=b", ""} {
+		clients = append(clients, dial(t, &Dialer{}, url+query))
+	}
+	waitHub(t, hub, 4)
+	if a, b := hub.RoomLen("a"), hub.RoomLen("b"); a != 2 || b != 1 {
+		t.Fatalf("RoomLen() = %d, %d, want 2, 1", a, b)
+	}
+
+	data := []byte("to a")
+	if err := hub.BroadcastRoom("a", frames.OpText, data); err != nil {
+		t.Fatal(err)
+	}
+	// The message is copied, so the caller may reuse data.
+	copy(data, "XXXX")
+	if err := hub.Broadcast(frames.OpBinary, []byte("to all")); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Broadcast(frames.OpPing, nil); err == nil {
+		t.Error("Broadcast(OpPing) error = nil")
+	}
+	for i, want := range [][]string{{"to a", "to all"}, {"to a", "to all"}, {"to all"}, {"to all"}} {
+		var got []string
+		for range want {
+			_, data, err := clients[i].ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(data))
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("client %d received %q, want %q", i, got, want)
+		}
+	}
+
+	if err := clients[2].Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitHub(t, hub, 3)
+	if n := hub.RoomLen("b"); n != 0 {
+		t.Errorf("RoomLen() after the connection closed = %d, want 0", n)
+	}
+}
+
+func TestHubLeaveAndRemove(t *testing.T) {
+	hub := &Hub{}
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		_, _, _ = ws.ReadMessage()
+	})
+	ws := dial(t, &Dialer{}, url)
+	for _, room := range []string{"a", "b"} {
+		if err := hub.Join(ws, room); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hub.Leave(ws, "a")
+	if a, b := hub.RoomLen("a"), hub.RoomLen("b"); hub.Len() != 1 || a != 0 || b != 1 {
+		t.Errorf("after Leave() Len() = %d, RoomLen() = %d, %d, want 1, 0, 1", hub.Len(), a, b)
+	}
+	hub.Remove(ws)
+	if hub.Len() != 0 || hub.RoomLen("b") != 0 {
+		t.Errorf("after Remove() Len() = %d, RoomLen() = %d, want 0, 0", hub.Len(), hub.RoomLen("b"))
+	}
+	if err := ws.WriteTextMessage("still open"); err != nil {
+		t.Errorf("WriteTextMessage() after Remove() error = %v, want the connection open", err)
+	}
+	_ = ws.closeConn()
+	if err := hub.Add(ws); err == nil {
+		t.Error("Add() of a closed connection error = nil")
+	}
+}
+
+func TestHubEvictsSlowConnection(t *testing.T) {
+	hub := &Hub{QueueSize: 1}
+	closed := make(chan time.Time, 1)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		joinHub(t, hub)(ws, r)
+		closed <- time.Now()
+	})
+	// The client never reads, so the writes to it get stuck once the socket buffers are full.
+	dial(t, &Dialer{}, url)
+	waitHub(t, hub, 1)
+	data := make([]byte, 1<<20)
+	deadline := time.Now().Add(10 * time.Second)
+	for hub.Len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the slow connection was not removed from the hub")
+		}
+		_ = hub.Broadcast(frames.OpBinary, data)
+	}
+	evicted := time.Now()
+	// Its close frame is stuck behind the pending write, so the connection is closed once hubEvictTimeout expires.
+	select {
+	case at := <-closed:
+		if elapsed := at.Sub(evicted); elapsed > hubEvictTimeout+time.Second {
+			t.Errorf("the connection was closed %v after the eviction, want at most about %v", elapsed, hubEvictTimeout)
+		}
+	case <-time.After(hubEvictTimeout + 5*time.Second):
+		t.Fatal("the evicted connection was not closed")
+	}
+}
@@ -0,0 +1,119 @@
+package websock
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReadWriteJSON(t *testing.T) {
+	url := serve(t, &Upgrader{Compression: &CompressionOptions{}}, func(ws *WebSocket, r *http.Request) {
+		if err := ws.SetWriteMessageType(frames.OpText); err != nil {
+			t.Error(err)
+			return
+		}
+		dec := json.NewDecoder(ws)
+		enc := json.NewEncoder(ws)
+		for {
+			var v struct{ N int }
+			if err := dec.Decode(&v); err != nil {
+				return
+			}
+			v.N++
+			if err := enc.Encode(v); err != nil {
+				return
+			}
+		}
+	})
+	ws := dial(t, &Dialer{Compression: &CompressionOptions{}}, url)
+	// A JSON value may span messages and a message may hold several values.
+	for _, chunk := range []string{`{"N":`, "1}\n{\"N\":", "41}\n"} {
+		if _, err := io.WriteString(ws, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messageType, r, err := ws.NextReader()
+	if err != nil || messageType != frames.OpText {
+		t.Fatalf("NextReader() = %v, %v, want a text message", messageType, err)
+	}
+	if data, _ := io.ReadAll(r); string(data) != "{\"N\":2}\n" {
+		t.Errorf("first message = %q", data)
+	}
+	sc := bufio.NewScanner(ws)
+	if !sc.Scan() || sc.Text() != `{"N":42}` {
+		t.Errorf("second line = %q, %v", sc.Text(), sc.Err())
+	}
+}
+
+func TestWriteSendsBinaryMessages(t *testing.T) {
+	messages := make(chan frames.Opcode, 2)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		for {
+			messageType, _, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			messages <- messageType
+		}
+	})
+	ws := dial(t, &Dialer{}, url)
+	if n, err := ws.Write([]byte("raw")); n != 3 || err != nil {
+		t.Fatalf("Write() = %d, %v", n, err)
+	}
+	if err := ws.SetWriteMessageType(frames.OpPing); err == nil {
+		t.Error("SetWriteMessageType(OpPing) error = nil")
+	}
+	if err := ws.SetWriteMessageType(frames.OpText); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.Write([]byte("text")); err != nil {
+		t.Fatal(err)
+	}
+	if got := []frames.Opcode{<-messages, <-messages}; got[0] != frames.OpBinary || got[1] != frames.OpText {
+		t.Errorf("message types = %v, want [binary text]", got)
+	}
+}
+
+func TestReadAcrossFrames(t *testing.T) {
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		_ = ws.WriteFragmentedMessage([]byte("hello, fragmented "), 4, frames.OpBinary)
+		_ = ws.WriteTextMessage("world")
+		_ = ws.CloseWithCode(frames.NormalClosure, "")
+	})
+	ws := dial(t, &Dialer{}, url)
+	data, err := io.ReadAll(ws)
+	if err != nil || string(data) != "hello, fragmented world" {
+		t.Fatalf("io.ReadAll() = %q, %v", data, err)
+	}
+	if n, err := ws.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read() after close = %d, %v, want io.EOF", n, err)
+	}
+}
+
+func TestReadDeadlineBetweenMessages(t *testing.T) {
+	release := make(chan struct{})
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		<-release
+		_ = ws.WriteTextMessage("late")
+		_, _, _ = ws.ReadMessage()
+	})
+	ws := dial(t, &Dialer{}, url)
+	if err := ws.Conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	var netErr interface{ Timeout() bool }
+	if _, err := ws.Read(make([]byte, 4)); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Read() error = %v, want a timeout", err)
+	}
+	close(release)
+	_ = ws.Conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(ws, buf); err != nil || string(buf) != "late" {
+		t.Errorf("Read() after the timeout = %q, %v", buf, err)
+	}
+}
@@ -0,0 +1,174 @@
+package websock
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rttSmoothingFactor is the weight of a new round-trip time sample in RTTStats.Smoothed (RFC 6298 section 2).
+const rttSmoothingFactor = 8
+
+// ErrPongTimeout is returned by reads once the connection was failed because the pong to a keepalive ping did not
+// arrive in time.
+var ErrPongTimeout = errors.New("keepalive pong timeout")
+
+// KeepaliveOptions configures the automatic keepalive pings of a connection.
+type KeepaliveOptions struct {
+	// Interval is the time between pings.
+	Interval time.Duration
+	// Timeout is the time to wait for the pong to a ping, from when the ping was written, before the connection is
+	// failed. Zero means Interval.
+	Timeout time.Duration
+}
+
+// RTTStats are the round-trip time estimates of a connection measured with keepalive pings.
+type RTTStats struct {
+	// Last is the round-trip time of the latest ping.
+	Last time.Duration
+	// Min is the lowest round-trip time measured.
+	Min time.Duration
+	// Smoothed is an exponentially weighted moving average of the round-trip times.
+	Smoothed time.Duration
+	// Samples is the number of pongs received to keepalive pings.
+	Samples int
+}
+
+// keepalive is the state of the keepalive pings of a connection.
+type keepalive struct {
+	mu       sync.Mutex
+	interval time.Duration
+	timeout  time.Duration
+	seq      uint64
+	pending  bool
+	sent     time.Time
+	timer    *time.Timer
+	rtt      RTTStats
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// StartKeepalive sends a ping every opts.Interval and fails the connection by closing it without a close frame, which
+// the peer observes as an abnormal closure (frames.NoStatusCode1006), if the pong does not arrive within opts.Timeout.
+// Pongs are only received while the connection is being read. The keepalive stops when the connection is closed.
+func (ws *WebSocket) StartKeepalive(opts KeepaliveOptions) error {
+	if opts.Interval <= 0 {
+		return errors.New("keepalive interval must be positive")
+	}
+	k := &keepalive{interval: opts.Interval, timeout: opts.Timeout, stop: make(chan struct{})}
+	if k.timeout <= 0 {
+		k.timeout = k.interval
+	}
+	if !ws.keepalive.CompareAndSwap(nil, k) {
+		return errors.New("keepalive already started")
+	}
+	go ws.keepaliveLoop(k)
+	return nil
+}
+
+// RTT returns the round-trip time estimates measured by the keepalive pings.
+func (ws *WebSocket) RTT() RTTStats {
+	k := ws.keepalive.Load()
+	if k == nil {
+		return RTTStats{}
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.rtt
+}
+
+// keepaliveLoop sends the keepalive pings until the keepalive is stopped or a ping cannot be sent. No new ping is sent
+// while the pong to the previous one is outstanding.
+func (ws *WebSocket) keepaliveLoop(k *keepalive) {
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.stop:
+			return
+		case <-ticker.C:
+		}
+		k.mu.Lock()
+		if k.pending {
+			k.mu.Unlock()
+			continue
+		}
+		k.seq++
+		seq := k.seq
+		k.pending = true
+		k.timer = nil
+		k.mu.Unlock()
+		if err := ws.WritePingMessage(strconv.FormatUint(seq, 10)); err != nil {
+			return
+		}
+		// The round trip and the pong timeout start once the ping is written, unless the pong already arrived.
+		k.mu.Lock()
+		select {
+		case <-k.stop:
+		default:
+			if k.pending {
+				k.sent = time.Now()
+				k.timer = time.AfterFunc(k.timeout, func() { ws.keepaliveExpired(k, seq) })
+			}
+		}
+		k.mu.Unlock()
+	}
+}
+
+// keepaliveExpired fails the connection if the pong to ping seq has not arrived.
+func (ws *WebSocket) keepaliveExpired(k *keepalive, seq uint64) {
+	k.mu.Lock()
+	expired := k.pending && k.seq == seq
+	k.mu.Unlock()
+	if expired {
+		ws.setCloseErr(ErrPongTimeout)
+		_ = ws.closeConn()
+	}
+}
+
+// handlePong records the round-trip time if payload answers the outstanding keepalive ping.
+func (ws *WebSocket) handlePong(payload []byte) {
+	k := ws.keepalive.Load()
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !k.pending || string(payload) != strconv.FormatUint(k.seq, 10) {
+		return
+	}
+	k.pending = false
+	if k.timer == nil {
+		// The pong arrived before the write of the ping returned, which leaves no round trip to measure.
+		return
+	}
+	k.timer.Stop()
+	rtt := time.Since(k.sent)
+	k.rtt.Last = rtt
+	if k.rtt.Samples == 0 || rtt < k.rtt.Min {
+		k.rtt.Min = rtt
+	}
+	if k.rtt.Samples == 0 {
+		k.rtt.Smoothed = rtt
+	} else {
+		k.rtt.Smoothed += (rtt - k.rtt.Smoothed) / rttSmoothingFactor
+	}
+	k.rtt.Samples++
+}
+
+// stopKeepalive stops the keepalive pings of the connection, if they were started.
+func (ws *WebSocket) stopKeepalive() {
+	k := ws.keepalive.Load()
+	if k == nil {
+		return
+	}
+	k.stopOnce.Do(func() {
+		close(k.stop)
+		k.mu.Lock()
+		if k.timer != nil {
+			k.timer.Stop()
+		}
+		k.mu.Unlock()
+	})
+}
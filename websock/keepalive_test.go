@@ -0,0 +1,136 @@
+package websock
+
+import (
+	"bufio"
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestKeepaliveRTT(t *testing.T) {
+	server := make(chan *WebSocket, 1)
+	u := &Upgrader{Keepalive: &KeepaliveOptions{Interval: 5 * time.Millisecond}}
+	url := serve(t, u, func(ws *WebSocket, r *http.Request) {
+		server <- ws
+		_, _, _ = ws.ReadMessage()
+	})
+	ws := dial(t, &Dialer{}, url)
+	// The client answers the pings while it reads.
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	serverWS := <-server
+	deadline := time.Now().Add(5 * time.Second)
+	for serverWS.RTT().Samples < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("RTT() = %+v, want 3 samples", serverWS.RTT())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	rtt := serverWS.RTT()
+	if rtt.Min <= 0 || rtt.Min > rtt.Last || rtt.Smoothed < rtt.Min {
+		t.Errorf("RTT() = %+v, want consistent estimates", rtt)
+	}
+	if got := ws.RTT(); got != (RTTStats{}) {
+		t.Errorf("RTT() without keepalive = %+v, want zero", got)
+	}
+}
+
+func TestKeepalivePongTimeout(t *testing.T) {
+	readErr := make(chan error, 1)
+	u := &Upgrader{Keepalive: &KeepaliveOptions{Interval: 10 * time.Millisecond, Timeout: 30 * time.Millisecond}}
+	url := serve(t, u, func(ws *WebSocket, r *http.Request) {
+		_, _, err := ws.ReadMessage()
+		readErr <- err
+	})
+	// The client does not read, so it never answers the ping.
+	ws := dial(t, &Dialer{}, url)
+	select {
+	case err := <-readErr:
+		if !errors.Is(err, ErrPongTimeout) {
+			t.Errorf("ReadMessage() error = %v, want %v", err, ErrPongTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not failed after the pong timeout")
+	}
+	// The queued ping is answered and the connection ends without a close frame.
+	var closeErr *CloseError
+	if _, _, err := ws.ReadMessage(); err == nil || errors.As(err, &closeErr) {
+		t.Errorf("client ReadMessage() error = %v, want an abnormal closure", err)
+	}
+}
+
+func TestStartKeepalive(t *testing.T) {
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		_, _, _ = ws.ReadMessage()
+	})
+	ws := dial(t, &Dialer{}, url)
+	if err := ws.StartKeepalive(KeepaliveOptions{}); err == nil {
+		t.Error("StartKeepalive() without an interval error = nil")
+	}
+	if err := ws.StartKeepalive(KeepaliveOptions{Interval: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.StartKeepalive(KeepaliveOptions{Interval: time.Hour}); err == nil {
+		t.Error("second StartKeepalive() error = nil")
+	}
+	// A pong which does not answer the outstanding ping is ignored.
+	k := ws.keepalive.Load()
+	k.mu.Lock()
+	k.seq, k.pending, k.sent, k.timer = 7, true, time.Now(), time.NewTimer(time.Hour)
+	k.mu.Unlock()
+	ws.handlePong([]byte("6"))
+	if got := ws.RTT(); got.Samples != 0 {
+		t.Errorf("RTT() after a stale pong = %+v, want no samples", got)
+	}
+	ws.handlePong([]byte("7"))
+	if got := ws.RTT(); got.Samples != 1 || got.Last != got.Min || got.Last != got.Smoothed {
+		t.Errorf("RTT() after the pong = %+v, want one sample", got)
+	}
+	// A pong which arrives before the write of its ping returned leaves no round trip to measure.
+	k.mu.Lock()
+	k.seq, k.pending, k.timer = 8, true, nil
+	k.mu.Unlock()
+	ws.handlePong([]byte("8"))
+	k.mu.Lock()
+	pending := k.pending
+	k.mu.Unlock()
+	if got := ws.RTT(); pending || got.Samples != 1 {
+		t.Errorf("after an early pong pending = %v, RTT() = %+v, want no new sample", pending, got)
+	}
+}
+
+func TestKeepaliveTimeoutStartsAfterWrite(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	ws := &WebSocket{Conn: conn, buff: bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)), isServer: true}
+	if err := ws.StartKeepalive(KeepaliveOptions{Interval: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	// The pipe is unbuffered and the peer does not read yet, so the ping is stuck in its write.
+	time.Sleep(100 * time.Millisecond)
+	if err := ws.closedErr(); err != nil {
+		t.Fatalf("connection failed with %v while the ping was written", err)
+	}
+	var frame frames.Frame
+	if err := frames.NewDecoder(peer).Decode(&frame); err != nil || frame.OpCode != frames.OpPing {
+		t.Fatalf("peer read %v, %v, want a ping", frame.OpCode, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for ws.closedErr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the unanswered ping did not fail the connection")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := ws.closedErr(); !errors.Is(err, ErrPongTimeout) {
+		t.Errorf("connection failed with %v, want %v", err, ErrPongTimeout)
+	}
+}
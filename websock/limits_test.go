@@ -0,0 +1,128 @@
+package websock
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"net/http"
+	"testing"
+)
+
+func TestSizeLimits(t *testing.T) {
+	random := make([]byte, 400)
+	_, _ = rand.Read(random)
+	tests := []struct {
+		name     string
+		send     func(ws *WebSocket) error
+		deflate  bool
+		wantOK   bool
+		wantErr  *SizeLimitError
+		wantCode frames.WebSocketStatusCode
+	}{
+		{
+			name:     "frame",
+			send:     func(ws *WebSocket) error { return ws.WriteBinaryMessage(random[:200]) },
+			wantErr:  &SizeLimitError{Limit: 100, Size: 200},
+			wantCode: frames.MessageTooBig,
+		},
+		{
+			name:     "message",
+			send:     func(ws *WebSocket) error { return ws.WriteFragmentedMessage(random, 90, frames.OpBinary) },
+			wantErr:  &SizeLimitError{Message: true, Limit: 300, Size: 360},
+			wantCode: frames.MessageTooBig,
+		},
+		{
+			// The decoded size is only known up to the read which exceeded the limit, so only the limit is checked.
+			name:     "deflate bomb",
+			send:     func(ws *WebSocket) error { return ws.WriteBinaryMessage(bytes.Repeat([]byte{'a'}, 10000)) },
+			deflate:  true,
+			wantCode: frames.MessageTooBig,
+		},
+		{
+			name:     "within limits",
+			send:     func(ws *WebSocket) error { return ws.WriteFragmentedMessage(make([]byte, 300), 90, frames.OpBinary) },
+			wantOK:   true,
+			wantCode: frames.NormalClosure,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readErr := make(chan error, 1)
+			u := &Upgrader{MaxFrameSize: 100, MaxMessageSize: 300, Compression: &CompressionOptions{}}
+			url := serve(t, u, func(ws *WebSocket, r *http.Request) {
+				_, data, err := ws.ReadMessage()
+				if err == nil && len(data) != 300 {
+					t.Errorf("ReadMessage() returned %d bytes, want 300", len(data))
+				}
+				readErr <- err
+				if err == nil {
+					_ = ws.CloseWithCode(frames.NormalClosure, "")
+				}
+			})
+			d := &Dialer{}
+			if tt.deflate {
+				d.Compression = &CompressionOptions{}
+			}
+			ws := dial(t, d, url)
+			if err := tt.send(ws); err != nil {
+				t.Fatal(err)
+			}
+
+			err := <-readErr
+			var sizeErr *SizeLimitError
+			switch {
+			case tt.wantOK:
+				if err != nil {
+					t.Fatalf("ReadMessage() error = %v", err)
+				}
+			case !errors.As(err, &sizeErr):
+				t.Fatalf("ReadMessage() error = %v, want a *SizeLimitError", err)
+			case tt.wantErr != nil && *sizeErr != *tt.wantErr:
+				t.Errorf("ReadMessage() error = %+v, want %+v", *sizeErr, *tt.wantErr)
+			case tt.wantErr == nil && (!sizeErr.Message || sizeErr.Limit != 300):
+				t.Errorf("ReadMessage() error = %+v, want the message limit", *sizeErr)
+			}
+
+			var closeErr *CloseError
+			if _, _, err = ws.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != tt.wantCode || !closeErr.Remote {
+				t.Errorf("client ReadMessage() error = %v, want a close with %v by the peer", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestReadFrameSizeLimit(t *testing.T) {
+	closeCode := make(chan frames.WebSocketStatusCode, 1)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		_ = ws.WriteBinaryMessage(make([]byte, 200))
+		_, _, err := ws.ReadMessage()
+		var closeErr *CloseError
+		if !errors.As(err, &closeErr) {
+			closeCode <- 0
+			return
+		}
+		closeCode <- closeErr.Code
+	})
+	ws := dial(t, &Dialer{MaxFrameSize: 100}, url)
+	var sizeErr *SizeLimitError
+	if _, err := ws.ReadFrame(); !errors.As(err, &sizeErr) || *sizeErr != (SizeLimitError{Limit: 100, Size: 200}) {
+		t.Errorf("ReadFrame() error = %v, want a *SizeLimitError", err)
+	}
+	if code := <-closeCode; code != frames.MessageTooBig {
+		t.Errorf("close status = %v, want %v", code, frames.MessageTooBig)
+	}
+}
+
+func TestReadFrameWithoutLimit(t *testing.T) {
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		// The header announces a 1 GiB payload, but only a few bytes arrive before the connection is closed.
+		frame := &frames.Frame{Fin: true, OpCode: frames.OpBinary, PayloadLength: 1 << 30}
+		_, _ = ws.Conn.Write(append(frame.AppendHeader(nil), 1, 2, 3))
+	})
+	ws := dial(t, &Dialer{}, url)
+	var sizeErr *SizeLimitError
+	if _, err := ws.ReadFrame(); err == nil || errors.As(err, &sizeErr) {
+		t.Errorf("ReadFrame() error = %v, want the truncated payload", err)
+	}
+}
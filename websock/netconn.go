@@ -0,0 +1,94 @@
+package websock
+
+import (
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// netConn is a net.Conn which carries a byte stream over the data messages of a WebSocket connection.
+type netConn struct {
+	ws       *WebSocket
+	opcode   frames.Opcode
+	readMu   sync.Mutex
+	closer   sync.Once
+	closeErr error
+}
+
+// NetConn returns a net.Conn which reads the payload of incoming data messages and sends every Write as a single
+// message of the given type, frames.OpText or frames.OpBinary. Deadlines apply to the underlying connection. A read
+// deadline which expires leaves the connection usable unless it interrupts a frame header or a compressed message, see
+// WebSocket.NextReader. Close performs the closing handshake with frames.NormalClosure. The WebSocket must not be used directly
+// once it is wrapped.
+func NetConn(ws *WebSocket, opcode frames.Opcode) net.Conn {
+	if opcode != frames.OpText {
+		opcode = frames.OpBinary
+	}
+	return &netConn{ws: ws, opcode: opcode}
+}
+
+// Read reads the payload of incoming data messages, returning io.EOF once the peer closed the connection.
+func (c *netConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	return c.ws.Read(p)
+}
+
+// Write sends p as a single data message.
+func (c *netConn) Write(p []byte) (int, error) {
+	var err error
+	if c.opcode == frames.OpText {
+		err = c.ws.WriteTextMessage(string(p))
+	} else {
+		err = c.ws.WriteBinaryMessage(p)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends a close frame with frames.NormalClosure and closes the underlying connection. It does nothing if the
+// peer already closed the connection.
+func (c *netConn) Close() error {
+	c.closer.Do(func() {
+		err := c.ws.CloseWithCode(frames.NormalClosure, "")
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			c.closeErr = err
+		}
+	})
+	return c.closeErr
+}
+
+// LocalAddr returns the local address of the underlying connection.
+func (c *netConn) LocalAddr() net.Addr {
+	return c.ws.Conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying connection.
+func (c *netConn) RemoteAddr() net.Addr {
+	return c.ws.Conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the underlying connection.
+func (c *netConn) SetDeadline(t time.Time) error {
+	return c.ws.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection.
+func (c *netConn) SetReadDeadline(t time.Time) error {
+	return c.ws.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying connection.
+func (c *netConn) SetWriteDeadline(t time.Time) error {
+	return c.ws.Conn.SetWriteDeadline(t)
+}
+
+// isTimeout returns true if err is a timeout of an expired deadline.
+func isTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,120 @@
+package websock
+
+import (
+	"bytes"
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNetConn(t *testing.T) {
+	copied := make(chan error, 1)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		c := NetConn(ws, frames.OpBinary)
+		_, err := io.Copy(c, c)
+		copied <- err
+		_ = c.Close()
+	})
+	ws := dial(t, &Dialer{}, url)
+	c := NetConn(ws, frames.OpText)
+	if c.LocalAddr().String() != ws.Conn.LocalAddr().String() || c.RemoteAddr().String() != ws.Conn.RemoteAddr().String() {
+		t.Errorf("addresses = %v, %v, want those of the underlying connection", c.LocalAddr(), c.RemoteAddr())
+	}
+
+	if err := c.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	var netErr net.Error
+	if _, err := c.Read(make([]byte, 1)); !errors.As(err, &netErr) || !netErr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read() error = %v, want a timeout", err)
+	}
+	if err := c.SetDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{"hello ", "world"} {
+		if n, err := c.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	buf := make([]byte, len("hello world"))
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello world" {
+		t.Fatalf("io.ReadFull() = %q, %v", buf, err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+	if err := <-copied; err != nil {
+		t.Errorf("server io.Copy() error = %v, want nil after the close handshake", err)
+	}
+	if _, err := c.Write([]byte("late")); err == nil {
+		t.Error("Write() after Close() error = nil")
+	}
+}
+
+func TestNetConnPeerClose(t *testing.T) {
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		c := NetConn(ws, frames.OpBinary)
+		_, _ = c.Write([]byte("bye"))
+		_ = c.Close()
+	})
+	c := NetConn(dial(t, &Dialer{}, url), frames.OpBinary)
+	data, err := io.ReadAll(c)
+	if err != nil || string(data) != "bye" {
+		t.Fatalf("io.ReadAll() = %q, %v", data, err)
+	}
+	if err = c.Close(); err != nil {
+		t.Errorf("Close() after the peer closed error = %v", err)
+	}
+}
+
+func TestNetConnDeadlineMidMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		partial []byte
+		rest    []byte
+	}{
+		{name: "in a payload", partial: []byte{0x82, 0x06, 1, 2, 3}, rest: []byte{4, 5, 6}},
+		{name: "between frames", partial: []byte{0x02, 0x03, 1, 2, 3}, rest: []byte{0x80, 0x03, 4, 5, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expired := make(chan struct{})
+			url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+				_, _ = ws.Conn.Write(tt.partial)
+				<-expired
+				_, _ = ws.Conn.Write(tt.rest)
+				_, _, _ = ws.ReadMessage()
+			})
+			c := NetConn(dial(t, &Dialer{}, url), frames.OpBinary)
+			if err := c.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 6)
+			n, err := io.ReadFull(c, buf)
+			close(expired)
+			if n != 3 || !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatalf("io.ReadFull() = %d, %v, want 3 bytes and a timeout", n, err)
+			}
+			// Once the deadline is extended, the message continues where the read stopped.
+			if err = c.SetReadDeadline(time.Time{}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = io.ReadFull(c, buf[n:]); err != nil || !bytes.Equal(buf, []byte{1, 2, 3, 4, 5, 6}) {
+				t.Errorf("io.ReadFull() after the timeout = %v, %v", buf, err)
+			}
+			if err = c.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package websock
+
+import (
+	"errors"
+	"fmt"
+	"github.com/blazskufca/gowebsock/frames"
+	"sync"
+	"unicode/utf8"
+)
+
+// PreparedMessage is a text or binary message which is encoded once and written to any number of connections with
+// WebSocket.WritePreparedMessage. The encoded frame is cached per server connection configuration: one uncompressed
+// frame, and one compressed frame per compression level of connections which negotiated permessage-deflate without
+// server context takeover. Connections whose frames cannot be shared, client connections which mask every frame and
+// connections which compress with context takeover or use other extensions, encode the message as usual.
+type PreparedMessage struct {
+	messageType frames.Opcode
+	data        []byte
+
+	mu      sync.Mutex
+	encoded map[preparedKey][]byte
+}
+
+// preparedKey identifies the encoding of a PreparedMessage, by the compression level of a compressed message.
+type preparedKey struct {
+	compressed bool
+	level      int
+}
+
+// NewPreparedMessage returns a prepared text or binary message with the payload data. data must not be modified
+// afterwards.
+func NewPreparedMessage(messageType frames.Opcode, data []byte) (*PreparedMessage, error) {
+	if messageType != frames.OpText && messageType != frames.OpBinary {
+		return nil, fmt.Errorf("invalid message type %v", messageType)
+	}
+	if messageType == frames.OpText && !utf8.Valid(data) {
+		return nil, errors.New("can not prepare text message with invalid UTF-8 in application data")
+	}
+	return &PreparedMessage{messageType: messageType, data: data, encoded: make(map[preparedKey][]byte)}, nil
+}
+
+// frame returns the encoded frame of the message for key, encoding it on first use.
+func (pm *PreparedMessage) frame(key preparedKey) ([]byte, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if encoded, ok := pm.encoded[key]; ok {
+		return encoded, nil
+	}
+	payload := pm.data
+	var rsv ReservedBits
+	if key.compressed {
+		var err error
+		chain := extensionChain{&deflateConn{level: key.level, writeNoContextTakeover: true}}
+		if payload, rsv, err = chain.encode(pm.messageType, pm.data); err != nil {
+			return nil, err
+		}
+	}
+	frame, err := frames.NewServerFrame(true, pm.messageType, payload)
+	if err != nil {
+		return nil, err
+	}
+	setFrameReservedBits(frame, rsv)
+	encoded, err := frame.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	pm.encoded[key] = encoded
+	return encoded, nil
+}
+
+// WritePreparedMessage sends a prepared message, reusing its encoded frame if the connection allows it.
+func (ws *WebSocket) WritePreparedMessage(pm *PreparedMessage) error {
+	ws.messageMu.Lock()
+	defer ws.messageMu.Unlock()
+	key, ok := ws.preparedKey()
+	if !ok {
+		if len(ws.extensions) > 0 {
+			return ws.writeEncodedMessage(pm.data, 0, pm.messageType)
+		}
+		frame, err := frames.NewServerFrame(true, pm.messageType, pm.data)
+		if err != nil {
+			return err
+		}
+		return ws.writeFrames([]*frames.Frame{frame})
+	}
+	encoded, err := pm.frame(key)
+	if err != nil {
+		return err
+	}
+	ws.frameMu.lock(false)
+	defer ws.frameMu.unlock()
+	if err = ws.checkWrite(&frames.Frame{OpCode: pm.messageType}); err != nil {
+		return err
+	}
+	if _, err = ws.buff.Write(encoded); err != nil {
+		return err
+	}
+	return ws.buff.Flush()
+}
+
+// preparedKey returns the encoding of prepared messages for the connection, or false if the encoded frames of prepared
+// messages can not be shared with it.
+func (ws *WebSocket) preparedKey() (preparedKey, bool) {
+	if !ws.isServer {
+		return preparedKey{}, false
+	}
+	switch len(ws.extensions) {
+	case 0:
+		return preparedKey{}, true
+	case 1:
+		d, ok := ws.extensions[0].(*deflateConn)
+		if !ok || !d.writeNoContextTakeover {
+			return preparedKey{}, false
+		}
+		return preparedKey{compressed: true, level: d.level}, true
+	default:
+		return preparedKey{}, false
+	}
+}
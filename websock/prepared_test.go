@@ -0,0 +1,90 @@
+package websock
+
+import (
+	"bytes"
+	"compress/flate"
+	"github.com/blazskufca/gowebsock/frames"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWritePreparedMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *CompressionOptions
+		wantKey preparedKey
+		shared  bool
+	}{
+		{name: "uncompressed", shared: true},
+		{
+			name:    "no context takeover",
+			opts:    &CompressionOptions{ServerNoContextTakeover: true},
+			wantKey: preparedKey{compressed: true, level: flate.DefaultCompression},
+			shared:  true,
+		},
+		{name: "context takeover", opts: &CompressionOptions{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte(strings.Repeat("hello prepared ", 200))
+			pm, err := NewPreparedMessage(frames.OpText, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys := make(chan []preparedKey, 1)
+			url := serve(t, &Upgrader{Compression: tt.opts}, func(ws *WebSocket, r *http.Request) {
+				for range 3 {
+					if ws.WritePreparedMessage(pm) != nil || ws.WriteTextMessage("plain") != nil {
+						return
+					}
+				}
+				pm.mu.Lock()
+				var cached []preparedKey
+				for key := range pm.encoded {
+					cached = append(cached, key)
+				}
+				pm.mu.Unlock()
+				keys <- cached
+				_, _, _ = ws.ReadMessage()
+			})
+			ws := dial(t, &Dialer{Compression: &CompressionOptions{}}, url)
+			for i := range 6 {
+				want := []byte("plain")
+				if i%2 == 0 {
+					want = data
+				}
+				if messageType, got, err := ws.ReadMessage(); err != nil || messageType != frames.OpText || !bytes.Equal(got, want) {
+					t.Fatalf("message %d = %v, %d bytes, %v", i, messageType, len(got), err)
+				}
+			}
+			cached := <-keys
+			if tt.shared && (len(cached) != 1 || cached[0] != tt.wantKey) {
+				t.Errorf("cached encodings = %v, want [%v]", cached, tt.wantKey)
+			} else if !tt.shared && len(cached) != 0 {
+				t.Errorf("cached encodings = %v, want none", cached)
+			}
+			// Client connections mask every frame, so they encode the message themselves.
+			if err := ws.WritePreparedMessage(pm); err != nil {
+				t.Errorf("client WritePreparedMessage() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestNewPreparedMessage(t *testing.T) {
+	for _, tt := range []struct {
+		messageType frames.Opcode
+		data        []byte
+	}{
+		{messageType: frames.OpPing, data: []byte("ping")},
+		{messageType: frames.OpText, data: []byte{'a', 0xff}},
+	} {
+		if _, err := NewPreparedMessage(tt.messageType, tt.data); err == nil {
+			t.Errorf("NewPreparedMessage(%v, %q) error = nil", tt.messageType, tt.data)
+		}
+	}
+	if _, err := NewPreparedMessage(frames.OpBinary, []byte{0xff}); err != nil {
+		t.Errorf("NewPreparedMessage() of binary data error = %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package websock
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// SOCKS5 protocol constants per RFC 1928 and RFC 1929.
+const (
+	socks5Version         byte = 0x05
+	socks5AuthNone        byte = 0x00
+	socks5AuthPassword    byte = 0x02
+	socks5AuthNoAccept    byte = 0xff
+	socks5PasswordVersion byte = 0x01
+	socks5CmdConnect      byte = 0x01
+	socks5AddrIPv4        byte = 0x01
+	socks5AddrDomain      byte = 0x03
+	socks5AddrIPv6        byte = 0x04
+	socks5Succeeded       byte = 0x00
+)
+
+// proxyHostPort returns the host and port of the proxy at u, using the default port of its scheme if it has none.
+func proxyHostPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+	switch u.Scheme {
+	case "https":
+		return net.JoinHostPort(u.Hostname(), "443")
+	case "socks5", "socks5h":
+		return net.JoinHostPort(u.Hostname(), "1080")
+	default:
+		return net.JoinHostPort(u.Hostname(), "80")
+	}
+}
+
+// proxyHandshake asks the proxy at proxyURL, connected through conn, to open a tunnel to addr. It returns the
+// connection to use for the tunnel, which for https proxies is wrapped in TLS.
+func (d *Dialer) proxyHandshake(ctx context.Context, conn net.Conn, proxyURL *url.URL, addr string) (net.Conn, error) {
+	switch proxyURL.Scheme {
+	case "http":
+		return conn, httpConnect(conn, proxyURL, addr)
+	case "https":
+		cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+		if d.TLSClientConfig != nil {
+			cfg = d.TLSClientConfig.Clone()
+		}
+		cfg.ServerName = proxyURL.Hostname()
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		return tlsConn, httpConnect(tlsConn, proxyURL, addr)
+	case "socks5", "socks5h":
+		return conn, socks5Connect(ctx, conn, proxyURL, addr)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+}
+
+// httpConnect opens a tunnel to addr with an HTTP CONNECT request, authenticating with the user information of
+// proxyURL if it has any.
+func httpConnect(conn net.Conn, proxyURL *url.URL, addr string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	br := bufio.NewReader(conn)
+	// The body of a successful response is the tunnel itself, so it is neither read nor closed.
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy CONNECT failed with status %s", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return errors.New("proxy sent data before the tunnel was established")
+	}
+	return nil
+}
+
+// socks5Connect opens a tunnel to addr through a SOCKS5 proxy, authenticating with the user information of proxyURL
+// if it has any. With the socks5 scheme host names are resolved locally, with socks5h they are resolved by the proxy.
+func socks5Connect(ctx context.Context, conn net.Conn, proxyURL *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	methods := []byte{socks5AuthNone}
+	if proxyURL.User != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+	if _, err = conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err = io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if proxyURL.User == nil {
+			return errors.New("SOCKS5 proxy requires authentication")
+		}
+		if err = socks5Authenticate(conn, proxyURL.User); err != nil {
+			return err
+		}
+	case socks5AuthNoAccept:
+		return errors.New("SOCKS5 proxy accepted none of the authentication methods")
+	default:
+		return fmt.Errorf("SOCKS5 proxy selected unsupported authentication method %d", reply[1])
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	ip := net.ParseIP(host)
+	if ip == nil && proxyURL.Scheme == "socks5" {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return err
+		}
+		if len(addrs) == 0 {
+			return fmt.Errorf("no addresses found for host %q", host)
+		}
+		ip = addrs[0].IP
+	}
+	switch {
+	case ip.To4() != nil:
+		req = append(append(req, socks5AddrIPv4), ip.To4()...)
+	case ip != nil:
+		req = append(append(req, socks5AddrIPv6), ip.To16()...)
+	case len(host) > 255:
+		return fmt.Errorf("host name %q is too long for SOCKS5", host)
+	default:
+		req = append(append(req, socks5AddrDomain, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+
+	var header [4]byte
+	if _, err = io.ReadFull(conn, header[:]); err != nil {
+		return err
+	}
+	if header[1] != socks5Succeeded {
+		return fmt.Errorf("SOCKS5 proxy CONNECT failed with reply code %d", header[1])
+	}
+	var boundLen int
+	switch header[3] {
+	case socks5AddrIPv4:
+		boundLen = net.IPv4len
+	case socks5AddrIPv6:
+		boundLen = net.IPv6len
+	case socks5AddrDomain:
+		var length [1]byte
+		if _, err = io.ReadFull(conn, length[:]); err != nil {
+			return err
+		}
+		boundLen = int(length[0])
+	default:
+		return fmt.Errorf("SOCKS5 proxy replied with unknown address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, boundLen+2))
+	return err
+}
+
+// socks5Authenticate performs the SOCKS5 username/password authentication of RFC 1929.
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("SOCKS5 username and password must be at most 255 bytes")
+	}
+	req := append([]byte{socks5PasswordVersion, byte(len(username))}, username...)
+	req = append(append(req, byte(len(password))), password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != socks5Succeeded {
+		return errors.New("SOCKS5 proxy rejected the username and password")
+	}
+	return nil
+}
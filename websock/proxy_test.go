@@ -0,0 +1,334 @@
+package websock
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// proxyRequest is a tunnel request received by a proxy stand-in.
+type proxyRequest struct {
+	// addr is the address the client asked to connect to.
+	addr string
+	// domain is true if a SOCKS5 client sent a host name rather than an IP address.
+	domain bool
+	// auth is the Proxy-Authorization header of an HTTP CONNECT request, or user:password of a SOCKS5 client.
+	auth string
+}
+
+// proxyStandIn is an in-process proxy which tunnels every accepted request to target, whatever address was asked
+// for, so tests can dial host names which do not resolve.
+type proxyStandIn struct {
+	target string
+	// user and password are required from clients if user is not empty.
+	user     string
+	password string
+	requests chan proxyRequest
+}
+
+// newProxyStandIn returns a proxy stand-in for the server at the ws:// URL target.
+func newProxyStandIn(target, user, password string) *proxyStandIn {
+	return &proxyStandIn{
+		target:   strings.TrimPrefix(target, "ws://"),
+		user:     user,
+		password: password,
+		requests: make(chan proxyRequest, 1),
+	}
+}
+
+// listen starts serving connections with serve and returns the address of the proxy.
+func (p *proxyStandIn) listen(t *testing.T, serve func(conn net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+// serveConnect serves a single HTTP CONNECT request.
+func (p *proxyStandIn) serveConnect(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil || req.Method != http.MethodConnect {
+		return
+	}
+	auth := req.Header.Get("Proxy-Authorization")
+	if p.user != "" {
+		r := &http.Request{Header: http.Header{"Authorization": {auth}}}
+		if user, password, ok := r.BasicAuth(); !ok || user != p.user || password != p.password {
+			_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+	}
+	p.requests <- proxyRequest{addr: req.Host, auth: auth}
+	upstream, err := net.Dial("tcp", p.target)
+	if err != nil {
+		_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	pipe(conn, upstream)
+}
+
+// serveSOCKS5 serves a single SOCKS5 CONNECT request.
+func (p *proxyStandIn) serveSOCKS5(conn net.Conn) {
+	defer conn.Close()
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil || header[0] != socks5Version {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	method := socks5AuthNone
+	if p.user != "" {
+		method = socks5AuthPassword
+	}
+	if !strings.ContainsRune(string(methods), rune(method)) {
+		_, _ = conn.Write([]byte{socks5Version, socks5AuthNoAccept})
+		return
+	}
+	_, _ = conn.Write([]byte{socks5Version, method})
+	var auth string
+	if method == socks5AuthPassword {
+		user, password, ok := readSOCKS5Password(conn)
+		if !ok || user != p.user || password != p.password {
+			_, _ = conn.Write([]byte{socks5PasswordVersion, 0x01})
+			return
+		}
+		_, _ = conn.Write([]byte{socks5PasswordVersion, socks5Succeeded})
+		auth = user + ":" + password
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil || req[1] != socks5CmdConnect {
+		return
+	}
+	var host string
+	switch req[3] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if req[3] == socks5AddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = ip.String()
+	case socks5AddrDomain:
+		name, ok := readSOCKS5String(conn)
+		if !ok {
+			return
+		}
+		host = name
+	default:
+		return
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return
+	}
+	p.requests <- proxyRequest{
+		addr:   net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))),
+		domain: req[3] == socks5AddrDomain,
+		auth:   auth,
+	}
+	upstream, err := net.Dial("tcp", p.target)
+	if err != nil {
+		_, _ = conn.Write([]byte{socks5Version, 0x05, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	_, _ = conn.Write([]byte{socks5Version, socks5Succeeded, 0x00, socks5AddrIPv4, 127, 0, 0, 1, 0, 0})
+	pipe(conn, upstream)
+}
+
+// readSOCKS5Password reads a RFC 1929 username/password request.
+func readSOCKS5Password(r io.Reader) (string, string, bool) {
+	var version [1]byte
+	if _, err := io.ReadFull(r, version[:]); err != nil || version[0] != socks5PasswordVersion {
+		return "", "", false
+	}
+	user, ok := readSOCKS5String(r)
+	if !ok {
+		return "", "", false
+	}
+	password, ok := readSOCKS5String(r)
+	return user, password, ok
+}
+
+// readSOCKS5String reads a string prefixed with its length in a single byte.
+func readSOCKS5String(r io.Reader) (string, bool) {
+	var length [1]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return "", false
+	}
+	s := make([]byte, length[0])
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", false
+	}
+	return string(s), true
+}
+
+// pipe copies data between a and b until either of them is closed.
+func pipe(a, b net.Conn) {
+	go func() {
+		_, _ = io.Copy(a, b)
+		_ = a.Close()
+	}()
+	_, _ = io.Copy(b, a)
+	_ = b.Close()
+}
+
+// echoThrough checks that ws echoes a message, which proves the tunnel works.
+func echoThrough(t *testing.T, ws *WebSocket) {
+	t.Helper()
+	if err := ws.WriteTextMessage("through the proxy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, data, err := ws.ReadMessage(); err != nil || string(data) != "through the proxy" {
+		t.Fatalf("ReadMessage() = %q, %v", data, err)
+	}
+}
+
+func TestDialHTTPProxy(t *testing.T) {
+	proxy := newProxyStandIn(serve(t, &Upgrader{}, echo), "gopher", "s3cret")
+	addr := proxy.listen(t, proxy.serveConnect)
+
+	d := &Dialer{Proxy: http.ProxyURL(&url.URL{Scheme: "http", User: url.UserPassword("gopher", "s3cret"), Host: addr})}
+	ws := dial(t, d, "ws://echo.test:8080/chat")
+	req := <-proxy.requests
+	if req.addr != "echo.test:8080" {
+		t.Errorf("CONNECT address = %q, want %q", req.addr, "echo.test:8080")
+	}
+	if want := "Basic Z29waGVyOnMzY3JldA=="; req.auth != want {
+		t.Errorf("Proxy-Authorization = %q, want %q", req.auth, want)
+	}
+	echoThrough(t, ws)
+
+	d.Proxy = http.ProxyURL(&url.URL{Scheme: "http", User: url.UserPassword("gopher", "wrong"), Host: addr})
+	if _, _, err := d.Dial(context.Background(), "ws://echo.test:8080/chat", nil); err == nil ||
+		!strings.Contains(err.Error(), "407") {
+		t.Errorf("Dial() with wrong credentials error = %v, want status 407", err)
+	}
+}
+
+func TestDialSOCKS5Proxy(t *testing.T) {
+	target := serve(t, &Upgrader{}, echo)
+	tests := []struct {
+		name       string
+		scheme     string
+		user       *url.Userinfo
+		host       string
+		wantDomain bool
+	}{
+		{name: "socks5", scheme: "socks5", host: "localhost"},
+		{name: "socks5 password", scheme: "socks5", user: url.UserPassword("gopher", "s3cret"), host: "localhost"},
+		{name: "socks5h", scheme: "socks5h", host: "echo.test", wantDomain: true},
+		{name: "socks5h password", scheme: "socks5h", user: url.UserPassword("gopher", "s3cret"), host: "echo.test",
+			wantDomain: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var user, password, wantAuth string
+			if tt.user != nil {
+				user = tt.user.Username()
+				password, _ = tt.user.Password()
+				wantAuth = user + ":" + password
+			}
+			proxy := newProxyStandIn(target, user, password)
+			addr := proxy.listen(t, proxy.serveSOCKS5)
+			d := &Dialer{Proxy: http.ProxyURL(&url.URL{Scheme: tt.scheme, User: tt.user, Host: addr})}
+			ws := dial(t, d, "ws://"+tt.host+":8080/")
+			req := <-proxy.requests
+			host, port, _ := net.SplitHostPort(req.addr)
+			if req.domain != tt.wantDomain || port != "8080" || tt.wantDomain && host != tt.host ||
+				!tt.wantDomain && !net.ParseIP(host).IsLoopback() {
+				t.Errorf("CONNECT address = %q (domain %v)", req.addr, req.domain)
+			}
+			if req.auth != wantAuth {
+				t.Errorf("credentials = %q, want %q", req.auth, wantAuth)
+			}
+			echoThrough(t, ws)
+		})
+	}
+}
+
+func TestDialSOCKS5ProxyRejectsCredentials(t *testing.T) {
+	proxy := newProxyStandIn(serve(t, &Upgrader{}, echo), "gopher", "s3cret")
+	addr := proxy.listen(t, proxy.serveSOCKS5)
+	for _, user := range []*url.Userinfo{nil, url.UserPassword("gopher", "wrong")} {
+		d := &Dialer{Proxy: http.ProxyURL(&url.URL{Scheme: "socks5h", User: user, Host: addr})}
+		if _, _, err := d.Dial(context.Background(), "ws://echo.test:8080/", nil); err == nil {
+			t.Errorf("Dial() with credentials %v error = nil", user)
+		}
+	}
+}
+
+// envProxy is the HTTP CONNECT proxy stand-in of TestDialProxyFromEnvironment. http.ProxyFromEnvironment reads the
+// environment only once per process, so the proxy outlives the test and keeps its address when the test is repeated.
+var envProxy = sync.OnceValues(func() (*proxyStandIn, string) {
+	proxy := &proxyStandIn{requests: make(chan proxyRequest, 1)}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go proxy.serveConnect(conn)
+		}
+	}()
+	return proxy, l.Addr().String()
+})
+
+func TestDialProxyFromEnvironment(t *testing.T) {
+	target := serve(t, &Upgrader{}, echo)
+	proxy, addr := envProxy()
+	proxy.target = strings.TrimPrefix(target, "ws://")
+	t.Setenv("HTTP_PROXY", "http://"+addr)
+	t.Setenv("NO_PROXY", "direct.test")
+
+	var dialed []string
+	d := &Dialer{
+		Proxy: http.ProxyFromEnvironment,
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			if strings.HasPrefix(addr, "direct.test:") {
+				addr = proxy.target
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+	echoThrough(t, dial(t, d, "ws://proxied.test:8080/"))
+	if req := <-proxy.requests; req.addr != "proxied.test:8080" {
+		t.Errorf("CONNECT address = %q, want %q", req.addr, "proxied.test:8080")
+	}
+	echoThrough(t, dial(t, d, "ws://direct.test:8080/"))
+	if want := []string{addr, "direct.test:8080"}; strings.Join(dialed, " ") != strings.Join(want, " ") {
+		t.Errorf("dialed %v, want %v", dialed, want)
+	}
+}
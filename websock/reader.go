@@ -0,0 +1,170 @@
+package websock
+
+import (
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"unicode/utf8"
+)
+
+// errStaleReader is returned by a message reader once NextReader was called again.
+var errStaleReader = errors.New("read from a message reader after the next message was requested")
+
+// frameReader streams the payload of an incoming message across its data frames, unmasking it as it arrives.
+type frameReader struct {
+	ws        *WebSocket
+	remaining uint64
+	key       [4]byte
+	masked    bool
+	pos       int
+	final     bool
+	size      uint64
+	err       error
+}
+
+// start begins reading the payload of a data frame whose header was read.
+func (r *frameReader) start(frame *frames.Frame) {
+	r.remaining = frame.PayloadLength
+	r.key = frame.MaskingKey
+	r.masked = frame.Masked
+	r.pos = 0
+	r.final = frame.Fin
+	r.size += frame.PayloadLength
+}
+
+func (r *frameReader) Read(p []byte) (int, error) {
+	for r.remaining == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.final {
+			r.err = io.EOF
+			return 0, r.err
+		}
+		frame, err := r.ws.nextFrame(true, r.size)
+		var closeErr *CloseError
+		if errors.As(err, &closeErr) {
+			err = io.ErrUnexpectedEOF
+		}
+		if r.ws.retryable(err) {
+			return 0, err
+		}
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		r.start(frame)
+	}
+	if uint64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.ws.buff.Read(p)
+	r.remaining -= uint64(n)
+	if r.masked {
+		r.pos = frames.MaskBytes(r.key, r.pos, p[:n])
+	}
+	// The rest of the payload is tracked, so a read which timed out can be retried.
+	if isTimeout(err) {
+		return n, err
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		r.err = r.ws.failRead(err)
+		return n, r.err
+	}
+	return n, nil
+}
+
+// messageReader is the reader of an incoming message returned by NextReader. It decodes the payload with the
+// negotiated extensions and enforces the message size limit and the UTF-8 encoding of text messages.
+type messageReader struct {
+	ws      *WebSocket
+	raw     *frameReader
+	r       io.Reader
+	decoded bool
+	text    bool
+	utf8    utf8Validator
+	size    int64
+	err     error
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	r.ws.readMu.Lock()
+	defer r.ws.readMu.Unlock()
+	return r.read(p)
+}
+
+// unfinished returns true if the payload of the message was not read to the end. The payload of a decoded message may
+// still be buffered by the decoder once all its frames were read.
+func (r *messageReader) unfinished() bool {
+	return r.err == nil && (r.decoded || r.raw.remaining > 0 || !r.raw.final)
+}
+
+// read implements Read for the goroutine which holds the read lock.
+func (r *messageReader) read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.r.Read(p)
+	switch {
+	case err == nil || err == io.EOF:
+	case r.ws.retryable(err):
+		// The decoders of the extensions do not resume after an error, so the rest of the message is lost.
+		if r.decoded {
+			err = r.ws.failRead(err)
+		}
+	case r.raw.err == nil || r.raw.err == io.EOF:
+		err = r.ws.fail(frames.ProtocolError, "extension failed to decode message", err)
+	}
+	r.size += int64(n)
+	if r.decoded && r.ws.maxMessageSize > 0 && r.size > r.ws.maxMessageSize {
+		sizeErr := &SizeLimitError{Message: true, Limit: r.ws.maxMessageSize, Size: uint64(r.size)}
+		err = r.ws.fail(frames.MessageTooBig, sizeErr.Error(), sizeErr)
+	}
+	if r.text && (!r.utf8.valid(p[:n]) || err == io.EOF && !r.utf8.complete()) {
+		err = r.ws.fail(frames.GotInconsistentData, "invalid UTF-8 in text message",
+			errors.New("protocol error: invalid UTF-8 in text message"))
+	}
+	if !r.ws.retryable(err) {
+		r.err = err
+	}
+	return n, err
+}
+
+// utf8Validator validates UTF-8 text which arrives in chunks, holding back a rune which is split between chunks.
+type utf8Validator struct {
+	pending [utf8.UTFMax]byte
+	n       int
+}
+
+// valid returns false if p continues the text with invalid UTF-8.
+func (v *utf8Validator) valid(p []byte) bool {
+	for v.n > 0 && len(p) > 0 {
+		v.pending[v.n] = p[0]
+		v.n++
+		p = p[1:]
+		if utf8.FullRune(v.pending[:v.n]) {
+			if r, size := utf8.DecodeRune(v.pending[:v.n]); r == utf8.RuneError && size == 1 {
+				return false
+			}
+			v.n = 0
+		}
+	}
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				v.n = copy(v.pending[:], p[i:])
+				p = p[:i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(p)
+}
+
+// complete returns true if the text does not end in the middle of a rune.
+func (v *utf8Validator) complete() bool {
+	return v.n == 0
+}
@@ -0,0 +1,149 @@
+package websock
+
+import (
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNextReaderInterleavedControlFrames(t *testing.T) {
+	messages := make(chan string, 2)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		_, r2, err := ws.NextReader()
+		if err == nil {
+			var data []byte
+			data, err = io.ReadAll(r2)
+			messages <- string(data)
+		}
+		if err != nil {
+			messages <- err.Error()
+			return
+		}
+		_, _, _ = ws.ReadMessage()
+	})
+	conn, br, _ := upgradeRaw(t, url, nil)
+	euro := []byte("€uro")
+	first, _ := frames.NewClientFrame(false, frames.OpText, euro[:1])
+	ping, _ := frames.NewClientFrame(true, frames.OpPing, []byte("hi"))
+	last, _ := frames.NewClientFrame(true, frames.OpContinuation, euro[1:])
+	var encoded []byte
+	for _, frame := range []*frames.Frame{first, ping, last} {
+		b, _ := frame.MarshalBinary()
+		encoded = append(encoded, b...)
+	}
+	if _, err := conn.Write(encoded); err != nil {
+		t.Fatal(err)
+	}
+	pong, err := frames.DecodeFrame(br)
+	if err != nil || pong.OpCode != frames.OpPong || string(pong.PayloadData) != "hi" {
+		t.Fatalf("DecodeFrame() = %+v, %v, want a pong", pong, err)
+	}
+	if got := <-messages; got != "€uro" {
+		t.Errorf("message = %q, want %q", got, "€uro")
+	}
+}
+
+func TestNextReaderDiscardsUnreadPayload(t *testing.T) {
+	for _, compression := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain", true: "deflate"}[compression], func(t *testing.T) {
+			var opts *CompressionOptions
+			if compression {
+				opts = &CompressionOptions{}
+			}
+			received := make(chan string, 2)
+			url := serve(t, &Upgrader{Compression: opts}, func(ws *WebSocket, r *http.Request) {
+				_, first, err := ws.NextReader()
+				if err != nil {
+					return
+				}
+				buf := make([]byte, 3)
+				_, _ = io.ReadFull(first, buf)
+				received <- string(buf)
+				_, second, err := ws.NextReader()
+				if err != nil {
+					return
+				}
+				if _, err = first.Read(buf); !errors.Is(err, errStaleReader) {
+					t.Errorf("Read() from a stale reader error = %v, want %v", err, errStaleReader)
+				}
+				data, _ := io.ReadAll(second)
+				received <- string(data)
+			})
+			ws := dial(t, &Dialer{Compression: opts}, url)
+			if err := ws.WriteFragmentedMessage([]byte(strings.Repeat("abcdef", 1000)), 7, frames.OpBinary); err != nil {
+				t.Fatal(err)
+			}
+			if err := ws.WriteTextMessage("after"); err != nil {
+				t.Fatal(err)
+			}
+			if got := []string{<-received, <-received}; got[0] != "abc" || got[1] != "after" {
+				t.Errorf("received %q, want [abc after]", got)
+			}
+		})
+	}
+}
+
+func TestNextReaderInvalidUTF8(t *testing.T) {
+	readErr := make(chan error, 1)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		_, r2, err := ws.NextReader()
+		if err == nil {
+			_, err = io.ReadAll(r2)
+		}
+		readErr <- err
+	})
+	ws := dial(t, &Dialer{}, url)
+	euro := []byte("€")
+	first, _ := frames.NewClientFrame(false, frames.OpText, euro[:2])
+	last, _ := frames.NewClientFrame(true, frames.OpContinuation, []byte{euro[2], 'a', 0xff})
+	if err := ws.WriteFrames([]*frames.Frame{first, last}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-readErr; err == nil {
+		t.Fatal("ReadAll() error = nil, want an error for invalid UTF-8")
+	}
+	var closeErr *CloseError
+	if _, _, err := ws.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != frames.GotInconsistentData {
+		t.Errorf("ReadMessage() error = %v, want a close with %v", err, frames.GotInconsistentData)
+	}
+}
+
+func TestUTF8Validator(t *testing.T) {
+	text := []byte("a€b😀c")
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		want   bool
+	}{
+		{name: "whole", chunks: [][]byte{text}, want: true},
+		{name: "split runes", chunks: [][]byte{text[:2], text[2:3], text[3:6], text[6:], nil}, want: true},
+		{name: "bytewise", chunks: splitBytes(text), want: true},
+		{name: "truncated", chunks: [][]byte{text[:7]}, want: false},
+		{name: "invalid", chunks: [][]byte{text[:2], {0xff}}, want: false},
+		{name: "overlong", chunks: [][]byte{{0xc0}, {0x80}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v utf8Validator
+			got := true
+			for _, chunk := range tt.chunks {
+				got = got && v.valid(chunk)
+			}
+			if got = got && v.complete(); got != tt.want {
+				t.Errorf("valid = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// splitBytes splits b into chunks of a single byte.
+func splitBytes(b []byte) [][]byte {
+	chunks := make([][]byte, len(b))
+	for i := range b {
+		chunks[i] = b[i : i+1]
+	}
+	return chunks
+}
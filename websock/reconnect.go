@@ -0,0 +1,269 @@
+package websock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/gowebsock/frames"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMinBackoff is the delay before the first reconnect when ReconnectingClient.MinBackoff is zero.
+	defaultMinBackoff time.Duration = 500 * time.Millisecond
+	// defaultMaxBackoff is the upper bound of the reconnect delay when ReconnectingClient.MaxBackoff is zero.
+	defaultMaxBackoff time.Duration = 30 * time.Second
+)
+
+// ErrSendBufferFull is returned by ReconnectingClient.Send when the client is disconnected and MaxBuffered messages
+// are already waiting to be sent.
+var ErrSendBufferFull = errors.New("reconnecting client send buffer is full")
+
+// ConnectionState is a state of the ReconnectingClient connection.
+type ConnectionState int
+
+const (
+	// StateConnecting is reported before every dial attempt.
+	StateConnecting ConnectionState = iota
+	// StateConnected is reported once a connection is established and the OnConnect hook succeeded.
+	StateConnected
+	// StateDisconnected is reported when a dial attempt fails or an established connection ends.
+	StateDisconnected
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "Connecting"
+	case StateConnected:
+		return "Connected"
+	case StateDisconnected:
+		return "Disconnected"
+	default:
+		return fmt.Sprintf("Unknown ConnectionState_%v", int(s))
+	}
+}
+
+// ConnectionEvent reports a change of the ReconnectingClient connection state.
+type ConnectionEvent struct {
+	State ConnectionState
+	// Attempt is the number of the dial attempt since the last established connection, starting at 1.
+	Attempt int
+	// Code is the close status code of a StateDisconnected event. It is frames.NoStatusCode1006 if the connection
+	// ended without a close frame or could not be established.
+	Code frames.WebSocketStatusCode
+	// Err is the error which ended the connection or failed the dial attempt, if any.
+	Err error
+}
+
+// ReconnectingClient keeps a client connection to a WebSocket server open. After an abnormal closure it re-dials with
+// exponential backoff and jitter, and replays OnConnect on every new connection.
+type ReconnectingClient struct {
+	// URL is the ws:// or wss:// URL of the server.
+	URL string
+	// Header is added to every handshake request.
+	Header http.Header
+	// Dialer opens the connections. If nil, DefaultDialer is used.
+	Dialer *Dialer
+	// OnConnect is called with every new connection before buffered messages are sent, e.g. to authenticate or to
+	// subscribe. An error closes the connection and counts as a failed dial attempt.
+	OnConnect func(ctx context.Context, ws *WebSocket) error
+	// OnMessage is called with every data message received.
+	OnMessage func(messageType frames.Opcode, data []byte)
+	// OnEvent is called on every connection state change.
+	OnEvent func(event ConnectionEvent)
+	// MinBackoff is the delay before the first reconnect. Zero means 500ms.
+	MinBackoff time.Duration
+	// MaxBackoff is the upper bound of the exponentially growing reconnect delay. Zero means 30s.
+	MaxBackoff time.Duration
+	// MaxBuffered is the number of messages Send buffers while the client is disconnected.
+	MaxBuffered int
+
+	mu      sync.Mutex
+	ws      *WebSocket
+	pending []bufferedMessage
+}
+
+// bufferedMessage is a message sent while the ReconnectingClient was disconnected.
+type bufferedMessage struct {
+	messageType frames.Opcode
+	data        []byte
+}
+
+// Run connects to the server and reads messages until ctx is done or the server closes the connection with
+// frames.NormalClosure. Every other closure and every failed dial attempt is followed by a reconnect.
+func (c *ReconnectingClient) Run(ctx context.Context) error {
+	dialer := c.Dialer
+	if dialer == nil {
+		dialer = DefaultDialer
+	}
+	attempt := 0
+	for {
+		attempt++
+		c.emit(ConnectionEvent{State: StateConnecting, Attempt: attempt})
+		ws, err := c.connect(ctx, dialer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.emit(ConnectionEvent{State: StateDisconnected, Attempt: attempt, Code: frames.NoStatusCode1006, Err: err})
+			if err = c.wait(ctx, attempt); err != nil {
+				return err
+			}
+			continue
+		}
+		c.emit(ConnectionEvent{State: StateConnected, Attempt: attempt})
+
+		code, err := c.readLoop(ctx, ws)
+		c.mu.Lock()
+		c.ws = nil
+		c.mu.Unlock()
+		c.emit(ConnectionEvent{State: StateDisconnected, Attempt: attempt, Code: code, Err: err})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if code == frames.NormalClosure {
+			return nil
+		}
+		attempt = 0
+		if err = c.wait(ctx, 1); err != nil {
+			return err
+		}
+	}
+}
+
+// Send sends a text or binary message, or buffers a copy of it while the client is disconnected or the connection is
+// closing.
+func (c *ReconnectingClient) Send(messageType frames.Opcode, data []byte) error {
+	if messageType != frames.OpText && messageType != frames.OpBinary {
+		return fmt.Errorf("invalid message type %v", messageType)
+	}
+	for {
+		c.mu.Lock()
+		ws := c.ws
+		if ws == nil {
+			defer c.mu.Unlock()
+			if len(c.pending) >= c.MaxBuffered {
+				return ErrSendBufferFull
+			}
+			c.pending = append(c.pending, bufferedMessage{messageType: messageType, data: bytes.Clone(data)})
+			return nil
+		}
+		c.mu.Unlock()
+		err := writeMessage(ws, messageType, data)
+		if !errors.Is(err, ErrConnectionClosing) {
+			return err
+		}
+		// The connection is going away, the message is sent on the next one.
+		c.mu.Lock()
+		if c.ws == ws {
+			c.ws = nil
+		}
+		c.mu.Unlock()
+	}
+}
+
+// connect dials a new connection, runs the OnConnect hook and sends the buffered messages.
+func (c *ReconnectingClient) connect(ctx context.Context, dialer *Dialer) (*WebSocket, error) {
+	ws, _, err := dialer.Dial(ctx, c.URL, c.Header)
+	if err != nil {
+		return nil, err
+	}
+	if c.OnConnect != nil {
+		if err = c.OnConnect(ctx, ws); err != nil {
+			_ = ws.CloseWithCode(frames.NormalClosure, "")
+			return nil, err
+		}
+	}
+	// Messages sent while the buffered ones are written are buffered as well, until none are left.
+	for {
+		c.mu.Lock()
+		pending := c.pending
+		c.pending = nil
+		if len(pending) == 0 {
+			c.ws = ws
+			c.mu.Unlock()
+			return ws, nil
+		}
+		c.mu.Unlock()
+		for i, m := range pending {
+			if err = writeMessage(ws, m.messageType, m.data); err != nil {
+				c.mu.Lock()
+				c.pending = append(pending[i:], c.pending...)
+				c.mu.Unlock()
+				_ = ws.closeConn()
+				return nil, err
+			}
+		}
+	}
+}
+
+// readLoop delivers the messages of ws until the connection ends and returns its close status code.
+func (c *ReconnectingClient) readLoop(ctx context.Context, ws *WebSocket) (frames.WebSocketStatusCode, error) {
+	stop := context.AfterFunc(ctx, func() {
+		_ = ws.CloseWithCode(frames.NormalClosure, "")
+	})
+	defer stop()
+	for {
+		messageType, data, err := ws.ReadMessage()
+		var closeErr *CloseError
+		if errors.As(err, &closeErr) {
+			return closeErr.Code, nil
+		}
+		if err != nil {
+			return frames.NoStatusCode1006, err
+		}
+		if c.OnMessage != nil {
+			c.OnMessage(messageType, data)
+		}
+	}
+}
+
+// wait sleeps for the backoff of the given reconnect attempt, or until ctx is done.
+func (c *ReconnectingClient) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(c.backoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// backoff returns the delay before the given reconnect attempt: the exponentially growing delay capped at MaxBackoff,
+// of which a random half is waited.
+func (c *ReconnectingClient) backoff(attempt int) time.Duration {
+	minBackoff, maxBackoff := c.MinBackoff, c.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	delay := minBackoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxBackoff)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// emit reports a connection event to OnEvent.
+func (c *ReconnectingClient) emit(event ConnectionEvent) {
+	if c.OnEvent != nil {
+		c.OnEvent(event)
+	}
+}
+
+// writeMessage sends a text or binary message on ws.
+func writeMessage(ws *WebSocket, messageType frames.Opcode, data []byte) error {
+	if messageType == frames.OpText {
+		return ws.WriteTextMessage(string(data))
+	}
+	return ws.WriteBinaryMessage(data)
+}
@@ -0,0 +1,167 @@
+package websock
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"net"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconnectingClient(t *testing.T) {
+	var connections atomic.Int32
+	received := make(chan string, 10)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		n := connections.Add(1)
+		for range n {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(data)
+		}
+		if n == 1 {
+			// Drop the first connection without a close frame, which is an abnormal closure.
+			_ = ws.closeConn()
+			return
+		}
+		_ = ws.WriteTextMessage("welcome back")
+		_ = ws.CloseWithCode(frames.NormalClosure, "")
+	})
+
+	var events []ConnectionEvent
+	var messages []string
+	var c *ReconnectingClient
+	c = &ReconnectingClient{
+		URL:         url,
+		Dialer:      &Dialer{},
+		MinBackoff:  10 * time.Millisecond,
+		MaxBuffered: 1,
+		OnConnect: func(ctx context.Context, ws *WebSocket) error {
+			return ws.WriteTextMessage("auth")
+		},
+		OnMessage: func(messageType frames.Opcode, data []byte) {
+			messages = append(messages, string(data))
+		},
+		OnEvent: func(event ConnectionEvent) {
+			events = append(events, event)
+			if event.State == StateDisconnected && len(events) == 3 {
+				if err := c.Send(frames.OpText, []byte("buffered")); err != nil {
+					t.Errorf("Send() while disconnected error = %v", err)
+				}
+				if err := c.Send(frames.OpText, []byte("overflow")); !errors.Is(err, ErrSendBufferFull) {
+					t.Errorf("Send() with a full buffer error = %v, want %v", err, ErrSendBufferFull)
+				}
+			}
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := []ConnectionEvent{
+		{State: StateConnecting, Attempt: 1},
+		{State: StateConnected, Attempt: 1},
+		{State: StateDisconnected, Attempt: 1, Code: frames.NoStatusCode1006},
+		{State: StateConnecting, Attempt: 1},
+		{State: StateConnected, Attempt: 1},
+		{State: StateDisconnected, Attempt: 1, Code: frames.NormalClosure},
+	}
+	for i := range events {
+		events[i].Err = nil
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+	var got []string
+	for range 3 {
+		got = append(got, <-received)
+	}
+	if !slices.Equal(got, []string{"auth", "auth", "buffered"}) {
+		t.Errorf("server received %q, want [auth auth buffered]", got)
+	}
+	if !slices.Equal(messages, []string{"welcome back"}) {
+		t.Errorf("client received %q, want [welcome back]", messages)
+	}
+}
+
+func TestReconnectingClientBuffersWhileClosing(t *testing.T) {
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		_, _, _ = ws.ReadMessage()
+	})
+	ws := dial(t, &Dialer{}, url)
+	if err := ws.WriteCloseMessage(frames.GoingAway, ""); err != nil {
+		t.Fatal(err)
+	}
+	c := &ReconnectingClient{MaxBuffered: 1, ws: ws}
+	if err := c.Send(frames.OpBinary, []byte("later")); err != nil {
+		t.Fatalf("Send() while closing error = %v", err)
+	}
+	if len(c.pending) != 1 || string(c.pending[0].data) != "later" || c.ws != nil {
+		t.Errorf("pending = %v, ws = %v, want the message buffered", c.pending, c.ws)
+	}
+}
+
+func TestReconnectingClientStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		cancel()
+		_, _, _ = ws.ReadMessage()
+	})
+	c := &ReconnectingClient{URL: url, Dialer: &Dialer{}}
+	if err := c.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestReconnectingClientBackoff(t *testing.T) {
+	c := &ReconnectingClient{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, want := range []time.Duration{0, 100, 200, 400, 800, 1000, 1000} {
+		if attempt == 0 {
+			continue
+		}
+		want *= time.Millisecond
+		for range 20 {
+			if got := c.backoff(attempt); got < want/2 || got > want {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", attempt, got, want/2, want)
+			}
+		}
+	}
+}
+
+func TestReconnectingClientSendDoesNotHoldLock(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	ws := &WebSocket{Conn: conn, buff: bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))}
+	c := &ReconnectingClient{ws: ws}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- c.Send(frames.OpBinary, []byte("stuck"))
+	}()
+	// The pipe is unbuffered and nobody reads, so the write blocks until the connection is closed.
+	for {
+		ws.frameMu.mu.Lock()
+		busy := ws.frameMu.busy
+		ws.frameMu.mu.Unlock()
+		if busy {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !c.mu.TryLock() {
+		t.Error("Send() holds the client lock while it writes")
+	} else {
+		c.mu.Unlock()
+	}
+	_ = ws.closeConn()
+	if err := <-sent; err == nil {
+		t.Error("Send() on a closed connection error = nil")
+	}
+}
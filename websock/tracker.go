@@ -0,0 +1,116 @@
+package websock
+
+import (
+	"context"
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"sync"
+)
+
+// ErrShuttingDown is returned when a connection is upgraded or tracked after Tracker.Shutdown was called.
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// Tracker tracks the open connections of a server, so they can be closed together when the server shuts down.
+// http.Server.Shutdown does not close hijacked connections, so upgraded connections are tracked separately, either by
+// setting Upgrader.Tracker or by calling Track. Connections are untracked once they are closed. The zero value is
+// ready to use.
+type Tracker struct {
+	mu       sync.Mutex
+	conns    map[*WebSocket]struct{}
+	shutdown bool
+	idle     chan struct{}
+}
+
+// Track adds ws to the tracked connections. It returns ErrShuttingDown once Shutdown was called, and does nothing if
+// ws is already closed.
+func (t *Tracker) Track(ws *WebSocket) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.shutdown {
+		return ErrShuttingDown
+	}
+	if _, ok := t.conns[ws]; ok {
+		return nil
+	}
+	if !ws.onClose(func() { t.untrack(ws) }) {
+		return nil
+	}
+	if t.conns == nil {
+		t.conns = make(map[*WebSocket]struct{})
+	}
+	t.conns[ws] = struct{}{}
+	return nil
+}
+
+// Len returns the number of tracked connections.
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// Shutdown closes every tracked connection with frames.GoingAway and the given reason, and refuses new connections.
+// Each connection waits for the peer's close frame up to its close timeout, see WebSocket.SetCloseTimeout. Shutdown
+// returns once every connection is closed, or closes the remaining connections without waiting for their close frames
+// and returns ctx.Err() when ctx is done first.
+func (t *Tracker) Shutdown(ctx context.Context, reason string) error {
+	t.mu.Lock()
+	t.shutdown = true
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+		if len(t.conns) == 0 {
+			close(t.idle)
+		}
+	}
+	idle := t.idle
+	conns := t.snapshot()
+	t.mu.Unlock()
+
+	for _, ws := range conns {
+		go func() {
+			_ = ws.CloseWithCode(frames.GoingAway, reason)
+		}()
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+	}
+	t.mu.Lock()
+	conns = t.snapshot()
+	t.mu.Unlock()
+	for _, ws := range conns {
+		ws.setCloseErr(ctx.Err())
+		_ = ws.closeConn()
+	}
+	return ctx.Err()
+}
+
+// shuttingDown returns true once Shutdown was called.
+func (t *Tracker) shuttingDown() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.shutdown
+}
+
+// snapshot returns the tracked connections. The caller must hold t.mu.
+func (t *Tracker) snapshot() []*WebSocket {
+	conns := make([]*WebSocket, 0, len(t.conns))
+	for ws := range t.conns {
+		conns = append(conns, ws)
+	}
+	return conns
+}
+
+// untrack removes a closed connection from the tracked connections.
+func (t *Tracker) untrack(ws *WebSocket) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.conns[ws]; !ok {
+		return
+	}
+	delete(t.conns, ws)
+	if len(t.conns) == 0 && t.idle != nil {
+		close(t.idle)
+	}
+}
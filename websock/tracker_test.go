@@ -0,0 +1,110 @@
+package websock
+
+import (
+	"context"
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// waitTracked waits until tr tracks n connections.
+func waitTracked(t *testing.T, tr *Tracker, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for tr.Len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Len() = %d, want %d", tr.Len(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTrackerShutdown(t *testing.T) {
+	tr := &Tracker{}
+	url := serve(t, &Upgrader{Tracker: tr}, func(ws *WebSocket, r *http.Request) {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	readErrs := make(chan error, 2)
+	for range 2 {
+		ws := dial(t, &Dialer{}, url)
+		go func() {
+			_, _, err := ws.ReadMessage()
+			readErrs <- err
+		}()
+	}
+	// A client which never reads does not answer the close frame.
+	dial(t, &Dialer{}, url)
+	waitTracked(t, tr, 3)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+	defer cancel()
+	if err := tr.Shutdown(ctx, "deploy"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := tr.Len(); n != 0 {
+		t.Errorf("Len() after Shutdown() = %d, want 0", n)
+	}
+	for range 2 {
+		var closeErr *CloseError
+		if err := <-readErrs; !errors.As(err, &closeErr) || closeErr.Code != frames.GoingAway || closeErr.Reason != "deploy" {
+			t.Errorf("client ReadMessage() error = %v, want a close with %v", err, frames.GoingAway)
+		}
+	}
+
+	_, resp, err := (&Dialer{}).Dial(t.Context(), url, nil)
+	if !errors.Is(err, ErrBadHandshake) || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Dial() after Shutdown() = %v, want status %d", err, http.StatusServiceUnavailable)
+	}
+	if err = tr.Track(&WebSocket{}); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Track() after Shutdown() error = %v, want %v", err, ErrShuttingDown)
+	}
+}
+
+func TestTrackerShutdownIdle(t *testing.T) {
+	if err := (&Tracker{}).Shutdown(t.Context(), ""); err != nil {
+		t.Errorf("Shutdown() without connections error = %v", err)
+	}
+	tr := &Tracker{}
+	url := serve(t, &Upgrader{Tracker: tr}, func(ws *WebSocket, r *http.Request) {
+		_, _, _ = ws.ReadMessage()
+	})
+	ws := dial(t, &Dialer{}, url)
+	go func() {
+		_, _, _ = ws.ReadMessage()
+	}()
+	waitTracked(t, tr, 1)
+	start := time.Now()
+	if err := tr.Shutdown(context.Background(), "bye"); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown() returned after %v, want it to return after the closing handshake", elapsed)
+	}
+}
+
+func TestTrackClosedConnection(t *testing.T) {
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		_, _, _ = ws.ReadMessage()
+	})
+	ws := dial(t, &Dialer{}, url)
+	tr := &Tracker{}
+	if err := tr.Track(ws); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Track(ws); err != nil || tr.Len() != 1 {
+		t.Errorf("second Track() = %v with %d connections, want 1", err, tr.Len())
+	}
+	_ = ws.closeConn()
+	if n := tr.Len(); n != 0 {
+		t.Errorf("Len() after the connection closed = %d, want 0", n)
+	}
+	if err := tr.Track(ws); err != nil || tr.Len() != 0 {
+		t.Errorf("Track() of a closed connection = %v with %d connections, want 0", err, tr.Len())
+	}
+}
@@ -0,0 +1,289 @@
+package websock
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/gowebsock/frames"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMethodNotAllowed is returned when the upgrade request method is not GET.
+	ErrMethodNotAllowed = errors.New("upgrade request method must be GET")
+	// ErrNotWebSocketUpgrade is returned when the request does not ask for an upgrade to the WebSocket protocol.
+	ErrNotWebSocketUpgrade = errors.New("not a WebSocket upgrade request")
+	// ErrUnsupportedVersion is returned when the Sec-WebSocket-Version of the request is not 13.
+	ErrUnsupportedVersion = errors.New("unsupported Sec-WebSocket-Version")
+	// ErrBadWebSocketKey is returned when the Sec-WebSocket-Key is not a base64 encoded 16 byte value.
+	ErrBadWebSocketKey = errors.New("invalid Sec-WebSocket-Key")
+	// ErrOriginNotAllowed is returned when the request Origin is rejected by Upgrader.CheckOrigin.
+	ErrOriginNotAllowed = errors.New("request origin not allowed")
+)
+
+// HandshakeError is returned when an upgrade request fails validation. Status is the HTTP status code the request
+// was answered with and Err is one of the ErrXxx validation errors.
+type HandshakeError struct {
+	Status int
+	Err    error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("websocket handshake failed with status %d: %v", e.Status, e.Err)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// Upgrader holds the options for upgrading an HTTP connection to a WebSocket connection.
+type Upgrader struct {
+	// ReadBufferSize is the size in bytes of the connection read buffer. If zero, the buffer allocated by the
+	// HTTP server is reused.
+	ReadBufferSize int
+	// WriteBufferSize is the size in bytes of the connection write buffer. If zero, the buffer allocated by the
+	// HTTP server is reused.
+	WriteBufferSize int
+	// HandshakeTimeout bounds the time spent writing the handshake response. Zero means no timeout.
+	HandshakeTimeout time.Duration
+	// CheckOrigin returns true if the Origin header of the request is acceptable. If nil, requests whose Origin
+	// host does not match the Host header are rejected.
+	CheckOrigin func(r *http.Request) bool
+	// Subprotocols lists the subprotocols supported by the server in order of preference. The first one offered by
+	// the client in Sec-WebSocket-Protocol is selected.
+	Subprotocols []string
+	// SelectSubprotocol, if set, is used instead of Subprotocols to pick one of the subprotocols offered by the
+	// client. Returning a protocol that was not offered or an empty string means no subprotocol is selected.
+	SelectSubprotocol func(r *http.Request, offered []string) string
+	// Compression enables the permessage-deflate extension (RFC 7692) when it is offered by the client. If nil,
+	// messages are never compressed.
+	Compression *CompressionOptions
+	// Extensions lists the extensions supported by the server, in addition to permessage-deflate enabled by
+	// Compression. Client offers are accepted in the order of client preference.
+	Extensions []Extension
+	// MaxFrameSize limits the payload size of incoming frames, see WebSocket.SetMaxFrameSize. Zero means no limit.
+	MaxFrameSize int64
+	// MaxMessageSize limits the payload size of incoming messages, see WebSocket.SetMaxMessageSize. Zero means no
+	// limit.
+	MaxMessageSize int64
+	// Keepalive enables automatic keepalive pings, see WebSocket.StartKeepalive. If nil, no pings are sent.
+	Keepalive *KeepaliveOptions
+	// CloseTimeout bounds the time Close waits for the peer's close frame, see WebSocket.SetCloseTimeout. Zero means
+	// 5 seconds.
+	CloseTimeout time.Duration
+	// Tracker, if set, tracks every upgraded connection, so Tracker.Shutdown closes it. Once Tracker.Shutdown was
+	// called, upgrade requests fail with status 503 and ErrShuttingDown.
+	Tracker *Tracker
+}
+
+// Upgrade upgrades the HTTP server connection to the WebSocket protocol. Headers in responseHeader are added to
+// the handshake response, except for those that are managed by the handshake itself.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*WebSocket, error) {
+	if err := validateUpgradeRequest(r, u.CheckOrigin); err != nil {
+		writeHandshakeError(w, err)
+		return nil, err
+	}
+	if u.Tracker != nil && u.Tracker.shuttingDown() {
+		err := &HandshakeError{Status: http.StatusServiceUnavailable, Err: ErrShuttingDown}
+		writeHandshakeError(w, err)
+		return nil, err
+	}
+
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	conn, buf, err := rc.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if u.HandshakeTimeout > 0 {
+		if err = conn.SetWriteDeadline(time.Now().Add(u.HandshakeTimeout)); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	extensions, extensionsHeader := negotiateExtensions(u.extensions(), r.Header)
+	buff, err := u.buffers(conn, buf)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	ws := &WebSocket{
+		Conn:             conn,
+		buff:             buff,
+		header:           r.Header,
+		status:           frames.NormalClosure,
+		responseHeader:   responseHeader,
+		subprotocol:      u.subprotocol(r),
+		extensions:       extensions,
+		extensionsHeader: extensionsHeader,
+		isServer:         true,
+		maxFrameSize:     u.MaxFrameSize,
+		maxMessageSize:   u.MaxMessageSize,
+		closeTimeout:     u.CloseTimeout,
+	}
+	if err = ws.Handshake(r); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if err = conn.SetWriteDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if u.Keepalive != nil {
+		if err = ws.StartKeepalive(*u.Keepalive); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	if u.Tracker != nil {
+		if err = u.Tracker.Track(ws); err != nil {
+			_ = ws.CloseWithCode(frames.GoingAway, err.Error())
+			return nil, err
+		}
+	}
+	return ws, nil
+}
+
+// extensions returns the extensions supported by the Upgrader.
+func (u *Upgrader) extensions() []Extension {
+	if u.Compression == nil {
+		return u.Extensions
+	}
+	return append([]Extension{DeflateExtension(u.Compression)}, u.Extensions...)
+}
+
+// buffers returns the read/write buffers of the connection, resized per the Upgrader configuration.
+func (u *Upgrader) buffers(conn net.Conn, hijacked *bufio.ReadWriter) (*bufio.ReadWriter, error) {
+	reader := hijacked.Reader
+	if u.ReadBufferSize > 0 {
+		if reader.Buffered() > 0 {
+			reader = bufio.NewReaderSize(reader, u.ReadBufferSize)
+		} else {
+			reader = bufio.NewReaderSize(conn, u.ReadBufferSize)
+		}
+	}
+	writer := hijacked.Writer
+	if u.WriteBufferSize > 0 {
+		if err := writer.Flush(); err != nil {
+			return nil, err
+		}
+		writer = bufio.NewWriterSize(conn, u.WriteBufferSize)
+	}
+	return bufio.NewReadWriter(reader, writer), nil
+}
+
+// validateUpgradeRequest checks that r is a valid WebSocket opening handshake per RFC 6455 section 4.2.1.
+func validateUpgradeRequest(r *http.Request, checkOrigin func(r *http.Request) bool) *HandshakeError {
+	if r.Method != http.MethodGet {
+		return &HandshakeError{Status: http.StatusMethodNotAllowed, Err: ErrMethodNotAllowed}
+	}
+	if !r.ProtoAtLeast(1, 1) || !tokenListContains(r.Header, "Upgrade", "websocket") ||
+		!tokenListContains(r.Header, "Connection", "upgrade") {
+		return &HandshakeError{Status: http.StatusBadRequest, Err: ErrNotWebSocketUpgrade}
+	}
+	if strings.TrimSpace(r.Header.Get("Sec-WebSocket-Version")) != "13" {
+		return &HandshakeError{Status: http.StatusUpgradeRequired, Err: ErrUnsupportedVersion}
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key")))
+	if err != nil || len(key) != 16 {
+		return &HandshakeError{Status: http.StatusBadRequest, Err: ErrBadWebSocketKey}
+	}
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(r) {
+		return &HandshakeError{Status: http.StatusForbidden, Err: ErrOriginNotAllowed}
+	}
+	return nil
+}
+
+// writeHandshakeError answers a failed upgrade request with the status of err.
+func writeHandshakeError(w http.ResponseWriter, err *HandshakeError) {
+	switch err.Status {
+	case http.StatusMethodNotAllowed:
+		w.Header().Set("Allow", http.MethodGet)
+	case http.StatusUpgradeRequired:
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		w.Header().Set("Upgrade", "websocket")
+	}
+	http.Error(w, err.Err.Error(), err.Status)
+}
+
+// tokenListContains returns true if the comma separated header values contain token, compared case-insensitively.
+func tokenListContains(header http.Header, name, token string) bool {
+	for _, t := range headerTokens(header, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSameOrigin returns true if the request has no Origin header or the Origin host matches the Host header.
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// headerTokens returns the comma separated tokens of all the header values with the given name.
+func headerTokens(header http.Header, name string) []string {
+	var tokens []string
+	for _, value := range header.Values(name) {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// subprotocol negotiates the subprotocol of the connection from the client offer in r.
+func (u *Upgrader) subprotocol(r *http.Request) string {
+	offered := headerTokens(r.Header, "Sec-WebSocket-Protocol")
+	if len(offered) == 0 {
+		return ""
+	}
+	if u.SelectSubprotocol != nil {
+		protocol := u.SelectSubprotocol(r, offered)
+		if slices.Contains(offered, protocol) {
+			return protocol
+		}
+		return ""
+	}
+	for _, protocol := range u.Subprotocols {
+		if slices.Contains(offered, protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
+
+// isHandshakeHeader returns true for the headers that can only be set by the handshake itself.
+func isHandshakeHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	return name == "Upgrade" || name == "Connection" || strings.HasPrefix(name, "Sec-Websocket-")
+}
@@ -0,0 +1,224 @@
+package websock
+
+import (
+	"context"
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpgraderResponseHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&Upgrader{}).Upgrade(w, r, http.Header{"X-Request-Id": {"42"}, "Upgrade": {"other"}})
+		if err != nil {
+			return
+		}
+		_ = ws.closeConn()
+	}))
+	defer srv.Close()
+	_, _, resp := upgradeRaw(t, "ws"+srv.URL[len("http"):], nil)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if got := resp.Header.Get("X-Request-Id"); got != "42" {
+		t.Errorf("X-Request-Id = %q, want %q", got, "42")
+	}
+	if got := resp.Header.Get("Upgrade"); got != "websocket" {
+		t.Errorf("Upgrade = %q, want %q", got, "websocket")
+	}
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("Sec-WebSocket-Accept = %q, want %q", got, want)
+	}
+}
+
+func TestUpgraderBufferSizes(t *testing.T) {
+	sizes := make(chan [2]int, 1)
+	url := serve(t, &Upgrader{ReadBufferSize: 1500, WriteBufferSize: 3000}, func(ws *WebSocket, r *http.Request) {
+		sizes <- [2]int{ws.buff.Reader.Size(), ws.buff.Writer.Size()}
+	})
+	dial(t, &Dialer{}, url)
+	if got := <-sizes; got != [2]int{1500, 3000} {
+		t.Errorf("buffer sizes = %v, want [1500 3000]", got)
+	}
+}
+
+func TestUpgraderCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name        string
+		checkOrigin func(r *http.Request) bool
+		origin      string
+		wantStatus  int
+	}{
+		{name: "no origin", wantStatus: http.StatusSwitchingProtocols},
+		{name: "same origin", origin: "http://example.com", wantStatus: http.StatusSwitchingProtocols},
+		{name: "cross origin", origin: "http://evil.example", wantStatus: http.StatusForbidden},
+		{
+			name:        "custom check",
+			checkOrigin: func(r *http.Request) bool { return r.Header.Get("Origin") == "http://evil.example" },
+			origin:      "http://evil.example",
+			wantStatus:  http.StatusSwitchingProtocols,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := upgradeRequest()
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			err := validateUpgradeRequest(r, tt.checkOrigin)
+			if tt.wantStatus == http.StatusSwitchingProtocols {
+				if err != nil {
+					t.Fatalf("validateUpgradeRequest() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Status != tt.wantStatus || !errors.Is(err, ErrOriginNotAllowed) {
+				t.Fatalf("validateUpgradeRequest() error = %v, want status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNewWebSocketWithUpgradeAcceptsAnyOrigin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := NewWebSocketWithUpgrade(w, r)
+		if err != nil {
+			return
+		}
+		_ = ws.WriteTextMessage("hello")
+		_ = ws.closeConn()
+	}))
+	defer srv.Close()
+	_, br, resp := upgradeRaw(t, "ws"+srv.URL[len("http"):], http.Header{"Origin": {"http://other.example"}})
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	frame, err := frames.DecodeFrame(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(frame.PayloadData) != "hello" {
+		t.Errorf("payload = %q, want %q", frame.PayloadData, "hello")
+	}
+}
+
+func TestUpgraderRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		modify     func(r *http.Request)
+		wantStatus int
+		wantErr    error
+		wantHeader http.Header
+	}{
+		{
+			name:       "method",
+			modify:     func(r *http.Request) { r.Method = http.MethodPost },
+			wantStatus: http.StatusMethodNotAllowed,
+			wantErr:    ErrMethodNotAllowed,
+			wantHeader: http.Header{"Allow": {http.MethodGet}},
+		},
+		{
+			name:       "no upgrade",
+			modify:     func(r *http.Request) { r.Header.Del("Upgrade") },
+			wantStatus: http.StatusBadRequest,
+			wantErr:    ErrNotWebSocketUpgrade,
+		},
+		{
+			name:       "version",
+			modify:     func(r *http.Request) { r.Header.Set("Sec-WebSocket-Version", "8") },
+			wantStatus: http.StatusUpgradeRequired,
+			wantErr:    ErrUnsupportedVersion,
+			wantHeader: http.Header{"Sec-Websocket-Version": {"13"}, "Upgrade": {"websocket"}},
+		},
+		{
+			name:       "key",
+			modify:     func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "c2hvcnQ=") },
+			wantStatus: http.StatusBadRequest,
+			wantErr:    ErrBadWebSocketKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := upgradeRequest()
+			tt.modify(r)
+			// httptest.ResponseRecorder can not be hijacked, so a failed request must be answered before the hijack.
+			w := httptest.NewRecorder()
+			_, err := (&Upgrader{}).Upgrade(w, r, nil)
+			var handshakeErr *HandshakeError
+			if !errors.As(err, &handshakeErr) || handshakeErr.Status != tt.wantStatus || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Upgrade() error = %v, want status %d and %v", err, tt.wantStatus, tt.wantErr)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("response status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			for name, values := range tt.wantHeader {
+				if got := w.Header().Get(name); got != values[0] {
+					t.Errorf("response header %s = %q, want %q", name, got, values[0])
+				}
+			}
+		})
+	}
+}
+
+func TestSubprotocolNegotiation(t *testing.T) {
+	tests := []struct {
+		name     string
+		upgrader *Upgrader
+		offered  []string
+		want     string
+	}{
+		{
+			name:     "server preference",
+			upgrader: &Upgrader{Subprotocols: []string{"graphql-transport-ws", "chat.v2"}},
+			offered:  []string{"chat.v2", "graphql-transport-ws"},
+			want:     "graphql-transport-ws",
+		},
+		{
+			name:     "not supported",
+			upgrader: &Upgrader{Subprotocols: []string{"chat.v2"}},
+			offered:  []string{"chat.v1"},
+		},
+		{
+			name:     "not offered",
+			upgrader: &Upgrader{Subprotocols: []string{"chat.v2"}},
+		},
+		{
+			name: "select",
+			upgrader: &Upgrader{SelectSubprotocol: func(r *http.Request, offered []string) string {
+				return offered[len(offered)-1]
+			}},
+			offered: []string{"chat.v1", "chat.v2"},
+			want:    "chat.v2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := make(chan string, 1)
+			url := serve(t, tt.upgrader, func(ws *WebSocket, r *http.Request) {
+				server <- ws.Subprotocol()
+			})
+			ws := dial(t, &Dialer{Subprotocols: tt.offered}, url)
+			if got := ws.Subprotocol(); got != tt.want {
+				t.Errorf("client Subprotocol() = %q, want %q", got, tt.want)
+			}
+			if got := <-server; got != tt.want {
+				t.Errorf("server Subprotocol() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectSubprotocolNotOffered(t *testing.T) {
+	u := &Upgrader{SelectSubprotocol: func(*http.Request, []string) string { return "other" }}
+	url := serve(t, u, func(ws *WebSocket, r *http.Request) {})
+	ws, resp, err := (&Dialer{Subprotocols: []string{"chat.v1"}}).Dial(context.Background(), url, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer ws.closeConn()
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != "" {
+		t.Errorf("Sec-WebSocket-Protocol = %q, want none", got)
+	}
+}
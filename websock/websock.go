@@ -2,16 +2,18 @@ package websock
 
 import (
 	"bufio"
-	"crypto/sha1"
-	"encoding/base64"
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/blazskufca/gowebsock/frames"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 )
@@ -19,76 +21,104 @@ import (
 const (
 	websocketGUID                  string = `258EAFA5-E914-47DA-95CA-C5AB0DC85B11`
 	switchingProtocolsResponseLine string = "HTTP/1.1 101 Switching Protocols\r\n"
+	// maxBatchWrite bounds the bytes of the frames written by a single write of a sequence of frames, so control frames
+	// are not held back until a long sequence is written.
+	maxBatchWrite int = 256 << 10
 )
 
-type WebSocket struct {
-	Conn   net.Conn
-	buff   *bufio.ReadWriter
-	header http.Header
-	status frames.WebSocketStatusCode
+// SizeLimitError is returned when an incoming frame or message exceeds a size limit of the connection. The connection
+// is closed with frames.MessageTooBig.
+type SizeLimitError struct {
+	// Message is true if the message size limit was exceeded and false if the frame size limit was exceeded.
+	Message bool
+	// Limit is the exceeded limit in bytes.
+	Limit int64
+	// Size is the size in bytes which exceeded the limit. For decoded messages it is the size read so far.
+	Size uint64
 }
 
-func NewWebSocketWithUpgrade(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
-	rc := http.NewResponseController(w)
-	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
-		return nil, err
-	}
-	if err := rc.SetReadDeadline(time.Time{}); err != nil {
-		return nil, err
-	}
-	conn, buf, err := rc.Hijack()
-	if err != nil {
-		return nil, err
-	}
-	if err = conn.SetWriteDeadline(time.Time{}); err != nil {
-		return nil, err
-	}
-	if err = conn.SetReadDeadline(time.Time{}); err != nil {
-		return nil, err
-	}
-	ws := &WebSocket{
-		Conn:   conn,
-		buff:   buf,
-		header: r.Header,
-		status: frames.NormalClosure,
+func (e *SizeLimitError) Error() string {
+	if e.Message {
+		return fmt.Sprintf("message size %d exceeds the limit of %d bytes", e.Size, e.Limit)
 	}
-	return ws, ws.Handshake(r)
+	return fmt.Sprintf("frame size %d exceeds the limit of %d bytes", e.Size, e.Limit)
 }
 
-func (ws *WebSocket) Handshake(r *http.Request) error {
-	upgrade := strings.ToLower(strings.TrimSpace(r.Header.Get("Upgrade")))
-	connection := strings.ToLower(strings.TrimSpace(r.Header.Get("Connection")))
-	wsKey := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
-	wsVersion := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Version"))
-
-	upgradeOK := upgrade == "websocket"
-	connectionOK := strings.Contains(connection, "upgrade")
-	keyOK := wsKey != ""
-	versionOK := wsVersion == "13"
+type WebSocket struct {
+	Conn             net.Conn
+	buff             *bufio.ReadWriter
+	header           http.Header
+	status           frames.WebSocketStatusCode
+	responseHeader   http.Header
+	subprotocol      string
+	extensions       extensionChain
+	extensionsHeader string
+	isServer         bool
+	reader           *messageReader
+	readErr          error
+	writeOpcode      frames.Opcode
+	messageMu        sync.Mutex
+	frameMu          frameLock
+	keepalive        atomic.Pointer[keepalive]
+	pingHandler      func(appData string) error
+	pongHandler      func(appData string) error
+	closeHandler     func(code frames.WebSocketStatusCode, reason string) error
+	readMu           sync.Mutex
+	closeMu          sync.Mutex
+	state            connState
+	closeSent        bool
+	closeErr         error
+	closeRecv        chan struct{}
+	closeTimeout     time.Duration
+	closeHooks       []func()
+	decoder          *frames.Decoder
+	inFrame          frames.Frame
+	controlPayload   [frames.PayloadLen125OrLess]byte
+	headerBuf        []byte
+	buffers          net.Buffers
+	maxFrameSize     int64
+	maxMessageSize   int64
+}
 
-	if !(upgradeOK && connectionOK && keyOK && versionOK) {
-		return errors.New("not a WebSocket UpgradeRequest")
-	}
+// NewWebSocketWithUpgrade upgrades the HTTP server connection using an Upgrader with default options.
+// Requests from any origin are accepted; use an Upgrader with CheckOrigin to restrict them.
+func NewWebSocketWithUpgrade(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
+	u := Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	return u.Upgrade(w, r, nil)
+}
 
-	extensions := ws.header.Get("Sec-WebSocket-Extensions")
-	if extensions != "" {
-		log.Printf("Client requested extensions: %s, but none are supported", extensions)
+// Handshake validates the opening handshake request and writes the 101 Switching Protocols response
+func (ws *WebSocket) Handshake(r *http.Request) error {
+	if err := validateUpgradeRequest(r, func(*http.Request) bool { return true }); err != nil {
+		return err
 	}
+	wsKey := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
 
-	sha1Hash := sha1.New()
-	sha1Hash.Write([]byte(wsKey))
-	sha1Hash.Write([]byte(websocketGUID))
 	_, err := ws.buff.WriteString(switchingProtocolsResponseLine)
 	if err != nil {
 		return err
 	}
 	respHeader := http.Header{
-		"Sec-WebSocket-Accept":  []string{base64.StdEncoding.EncodeToString(sha1Hash.Sum(nil))},
+		"Sec-WebSocket-Accept":  []string{acceptKey(wsKey)},
 		"Upgrade":               []string{"websocket"},
 		"Connection":            []string{"Upgrade"},
 		"Sec-WebSocket-Version": []string{"13"},
 		"Server":                []string{"GoWebSock"},
 	}
+	if ws.subprotocol != "" {
+		respHeader.Set("Sec-WebSocket-Protocol", ws.subprotocol)
+	}
+	if ws.extensionsHeader != "" {
+		respHeader.Set("Sec-WebSocket-Extensions", ws.extensionsHeader)
+	}
+	for name, values := range ws.responseHeader {
+		if isHandshakeHeader(name) || respHeader.Get(name) != "" {
+			continue
+		}
+		for _, value := range values {
+			respHeader.Add(name, value)
+		}
+	}
 	err = respHeader.Write(ws.buff)
 	if err != nil {
 		return err
@@ -100,49 +130,272 @@ func (ws *WebSocket) Handshake(r *http.Request) error {
 	return ws.buff.Flush()
 }
 
-// WriteFrames encodes and writes a sequence of frames
+// Subprotocol returns the subprotocol negotiated in the opening handshake, or an empty string if none was selected.
+func (ws *WebSocket) Subprotocol() string {
+	return ws.subprotocol
+}
+
+// SetMaxFrameSize limits the payload size of incoming frames. Zero or a negative limit means no limit.
+func (ws *WebSocket) SetMaxFrameSize(limit int64) {
+	ws.maxFrameSize = limit
+}
+
+// SetMaxMessageSize limits the payload size of incoming messages, after they are decoded by the negotiated
+// extensions. Zero or a negative limit means no limit.
+func (ws *WebSocket) SetMaxMessageSize(limit int64) {
+	ws.maxMessageSize = limit
+}
+
+// WriteFrames encodes and writes a sequence of frames. On a client connection, unmasked frames are masked while they
+// are encoded, leaving their payload untouched. It is safe for concurrent use: data frames written concurrently go
+// before or after the sequence but never in between its frames, while control frames may go in between the frames of
+// a long sequence.
 func (ws *WebSocket) WriteFrames(frames []*frames.Frame) error {
 	for _, frame := range frames {
-		encoded, err := frame.MarshalBinary()
-		if err != nil {
+		if !frame.IsControl() {
+			ws.messageMu.Lock()
+			defer ws.messageMu.Unlock()
+			break
+		}
+	}
+	return ws.writeFrames(frames)
+}
+
+// writeFrames encodes and writes a sequence of frames under the frame lock. The caller holds the message lock if the
+// sequence has data frames. The sequence is written in parts of up to maxBatchWrite bytes, and the frame lock is
+// handed to the control frames waiting for it between the parts.
+func (ws *WebSocket) writeFrames(batch []*frames.Frame) error {
+	control := true
+	for _, frame := range batch {
+		control = control && frame.IsControl()
+	}
+	ws.frameMu.lock(control)
+	defer ws.frameMu.unlock()
+	for len(batch) > 0 {
+		n, size := 0, 0
+		for ; n < len(batch); n++ {
+			frameSize := batch[n].HeaderSize() + len(batch[n].PayloadData)
+			if n > 0 && size+frameSize > maxBatchWrite {
+				break
+			}
+			size += frameSize
+		}
+		if err := ws.writeBatch(batch[:n], size); err != nil {
+			return err
+		}
+		batch = batch[n:]
+		if len(batch) > 0 {
+			ws.frameMu.yield(control)
+		}
+	}
+	return nil
+}
+
+// writeBatch encodes and writes a sequence of frames of size bytes for the goroutine which holds the frame lock and
+// flushes the connection. Frame headers are encoded into a scratch buffer and payloads are never copied to a new
+// allocation: small sequences are gathered in the write buffer, larger sequences of a server go out in a single
+// vectored write, and client payloads are masked into the write buffer.
+func (ws *WebSocket) writeBatch(batch []*frames.Frame, size int) error {
+	for _, frame := range batch {
+		if err := ws.checkWrite(frame); err != nil {
 			return err
 		}
-		if _, err := ws.buff.Write(encoded); err != nil {
+		if err := ws.extensions.writeFrame(frame); err != nil {
 			return err
 		}
 	}
+	switch {
+	case !ws.isServer:
+		return ws.writeMaskedFrames(batch)
+	case size <= ws.buff.Available():
+		for _, frame := range batch {
+			ws.headerBuf = frame.AppendHeader(ws.headerBuf[:0])
+			_, _ = ws.buff.Write(ws.headerBuf)
+			_, _ = ws.buff.Write(frame.PayloadData)
+		}
+		return ws.buff.Flush()
+	default:
+		return ws.writeVectored(batch)
+	}
+}
+
+// writeVectored writes a sequence of frames in a single vectored write, which sends the payloads without copying them.
+// Bytes left in the write buffer are flushed first.
+func (ws *WebSocket) writeVectored(batch []*frames.Frame) error {
+	if err := ws.buff.Flush(); err != nil {
+		return err
+	}
+	ws.headerBuf = ws.headerBuf[:0]
+	for _, frame := range batch {
+		ws.headerBuf = frame.AppendHeader(ws.headerBuf)
+	}
+	buffers := ws.buffers[:0]
+	offset := 0
+	for _, frame := range batch {
+		headerSize := frame.HeaderSize()
+		buffers = append(buffers, ws.headerBuf[offset:offset+headerSize])
+		offset += headerSize
+		if len(frame.PayloadData) > 0 {
+			buffers = append(buffers, frame.PayloadData)
+		}
+	}
+	ws.buffers = buffers
+	_, err := buffers.WriteTo(ws.Conn)
+	clear(ws.buffers)
+	return err
+}
+
+// writeMaskedFrames writes a sequence of client frames and flushes the connection. Payloads are masked with a new
+// random key in the free space of the write buffer, so the payload of the caller is neither modified nor copied to a
+// new allocation. Frames which are already masked are written as they are.
+func (ws *WebSocket) writeMaskedFrames(batch []*frames.Frame) error {
+	for _, frame := range batch {
+		masked := *frame
+		if !frame.Masked {
+			masked.Masked = true
+			if _, err := rand.Read(masked.MaskingKey[:]); err != nil {
+				return err
+			}
+		}
+		ws.headerBuf = masked.AppendHeader(ws.headerBuf[:0])
+		if _, err := ws.buff.Write(ws.headerBuf); err != nil {
+			return err
+		}
+		if frame.Masked {
+			if _, err := ws.buff.Write(frame.PayloadData); err != nil {
+				return err
+			}
+			continue
+		}
+		pos := 0
+		for payload := frame.PayloadData; len(payload) > 0; {
+			free := ws.buff.AvailableBuffer()
+			if cap(free) == 0 {
+				if err := ws.buff.Flush(); err != nil {
+					return err
+				}
+				continue
+			}
+			n := min(cap(free), len(payload))
+			free = append(free, payload[:n]...)
+			pos = frames.MaskBytes(masked.MaskingKey, pos, free)
+			if _, err := ws.buff.Write(free); err != nil {
+				return err
+			}
+			payload = payload[n:]
+		}
+	}
 	return ws.buff.Flush()
 }
 
 // WriteTextMessage sends a text message
 func (ws *WebSocket) WriteTextMessage(message string) error {
+	ws.messageMu.Lock()
+	defer ws.messageMu.Unlock()
+	return ws.writeText(message)
+}
+
+// writeText sends a text message for the goroutine which holds the message lock.
+func (ws *WebSocket) writeText(message string) error {
+	if len(ws.extensions) > 0 {
+		if !utf8.ValidString(message) {
+			return errors.New("can not send text message with invalid UTF-8 in application data")
+		}
+		return ws.writeEncodedMessage([]byte(message), 0, frames.OpText)
+	}
 	frame, err := frames.TextFrame(message, true)
 	if err != nil {
 		return err
 	}
-	return ws.WriteFrames([]*frames.Frame{frame})
+	return ws.writeFrames([]*frames.Frame{frame})
 }
 
 // WriteBinaryMessage sends a binary message
 func (ws *WebSocket) WriteBinaryMessage(data []byte) error {
+	ws.messageMu.Lock()
+	defer ws.messageMu.Unlock()
+	return ws.writeBinary(data)
+}
+
+// writeBinary sends a binary message for the goroutine which holds the message lock.
+func (ws *WebSocket) writeBinary(data []byte) error {
+	if len(ws.extensions) > 0 {
+		return ws.writeEncodedMessage(data, 0, frames.OpBinary)
+	}
 	frame, err := frames.BinaryFrame(data, true)
 	if err != nil {
 		return err
 	}
-	return ws.WriteFrames([]*frames.Frame{frame})
+	return ws.writeFrames([]*frames.Frame{frame})
 }
 
 // WriteFragmentedMessage sends a fragmented message
 func (ws *WebSocket) WriteFragmentedMessage(data []byte, maxFrameSize int, opcode frames.Opcode) error {
+	ws.messageMu.Lock()
+	defer ws.messageMu.Unlock()
+	if len(ws.extensions) > 0 {
+		if opcode == frames.OpText && !utf8.Valid(data) {
+			return errors.New("can not send text message with invalid UTF-8 in application data")
+		}
+		return ws.writeEncodedMessage(data, maxFrameSize, opcode)
+	}
 	frames, err := frames.FragmentedFrames(data, maxFrameSize, opcode, true)
 	if err != nil {
 		return err
 	}
-	return ws.WriteFrames(frames)
+	return ws.writeFrames(frames)
+}
+
+// NextWriter returns a writer of a new data message of type frames.OpText or frames.OpBinary. The payload is encoded
+// with the negotiated extensions and sent in a first data frame followed by continuation frames as the write buffer
+// fills, and the final frame is sent when the writer is closed. Other data messages are held back until the writer is
+// closed, so NextWriter blocks while a previous writer is open. Text messages must be written as valid UTF-8.
+func (ws *WebSocket) NextWriter(messageType frames.Opcode) (io.WriteCloser, error) {
+	if messageType != frames.OpText && messageType != frames.OpBinary {
+		return nil, fmt.Errorf("invalid message type %v", messageType)
+	}
+	ws.messageMu.Lock()
+	fw := &frameWriter{ws: ws, opcode: messageType, buf: make([]byte, 0, ws.buff.Writer.Size())}
+	m := &messageWriter{ws: ws, w: fw}
+	if len(ws.extensions) > 0 {
+		w, rsv, err := ws.extensions.writer(messageType, fw)
+		if err != nil {
+			ws.messageMu.Unlock()
+			return nil, err
+		}
+		fw.rsv = rsv
+		m.w = w
+	}
+	return m, nil
+}
+
+// writeEncodedMessage encodes data with the negotiated extensions and sends it as a message of frames with at most
+// maxFrameSize bytes of payload. A maxFrameSize of zero sends a single frame.
+func (ws *WebSocket) writeEncodedMessage(data []byte, maxFrameSize int, opcode frames.Opcode) error {
+	encoded, rsv, err := ws.extensions.encode(opcode, data)
+	if err != nil {
+		return err
+	}
+	if maxFrameSize <= 0 {
+		maxFrameSize = max(len(encoded), 1)
+	}
+	fragments, err := frames.FragmentedFrames(encoded, maxFrameSize, opcode, true)
+	if err != nil {
+		return err
+	}
+	setFrameReservedBits(fragments[0], rsv)
+	return ws.writeFrames(fragments)
 }
 
 // WriteCloseMessage sends a close frame
 func (ws *WebSocket) WriteCloseMessage(code frames.WebSocketStatusCode, reason string) error {
+	if code == frames.NoStatusCode1005 {
+		frame, err := frames.NewServerFrame(true, frames.OpClose, nil)
+		if err != nil {
+			return err
+		}
+		return ws.WriteFrames([]*frames.Frame{frame})
+	}
 	frame, err := frames.NewCloseFrame(code, reason, true)
 	if err != nil {
 		return err
@@ -171,23 +424,76 @@ func (ws *WebSocket) WritePongMessage(pingFrame *frames.Frame) error {
 	return ws.WriteFrames([]*frames.Frame{pongFrame})
 }
 
-// ReadFrame reads a single WebSocket frame
+// ReadFrame reads a single WebSocket frame. A frame which exceeds the frame size limit fails with a *SizeLimitError
+// before its payload is read, and the connection is closed with frames.MessageTooBig as by NextReader.
 func (ws *WebSocket) ReadFrame() (*frames.Frame, error) {
-	return frames.DecodeFrame(ws.buff)
+	ws.readMu.Lock()
+	defer ws.readMu.Unlock()
+	frame, err := ws.readFrame(0)
+	var sizeErr *SizeLimitError
+	if errors.As(err, &sizeErr) {
+		return nil, ws.fail(frames.MessageTooBig, sizeErr.Error(), err)
+	}
+	return frame, err
+}
+
+// readFrame reads a single frame of a message which already has buffered bytes of payload.
+func (ws *WebSocket) readFrame(buffered uint64) (*frames.Frame, error) {
+	frame := &frames.Frame{}
+	if err := ws.readFrameHeader(frame, buffered); err != nil {
+		return nil, err
+	}
+	if err := ws.decoder.ReadPayload(frame, nil); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// readFrameHeader reads the header of a frame of a message which already has buffered bytes of payload into frame,
+// and checks the size limits before the payload is allocated.
+func (ws *WebSocket) readFrameHeader(frame *frames.Frame, buffered uint64) error {
+	if ws.decoder == nil {
+		ws.decoder = frames.NewDecoder(ws.buff)
+	}
+	if err := ws.decoder.DecodeHeader(frame); err != nil {
+		return err
+	}
+	if ws.maxFrameSize > 0 && frame.PayloadLength > uint64(ws.maxFrameSize) {
+		return &SizeLimitError{Limit: ws.maxFrameSize, Size: frame.PayloadLength}
+	}
+	if ws.maxMessageSize > 0 && !frame.IsControl() && buffered+frame.PayloadLength > uint64(ws.maxMessageSize) {
+		return &SizeLimitError{Message: true, Limit: ws.maxMessageSize, Size: buffered + frame.PayloadLength}
+	}
+	return nil
 }
 
 // ValidateClientFrame validates a client frame per RFC 6455
 func (ws *WebSocket) ValidateClientFrame(fr *frames.Frame) error {
+	return ws.validateFrame(fr, true)
+}
+
+// ValidateServerFrame validates a server frame per RFC 6455
+func (ws *WebSocket) ValidateServerFrame(fr *frames.Frame) error {
+	return ws.validateFrame(fr, false)
+}
+
+// validateFrame validates a frame sent by the peer, which must be masked if it was sent by a client
+func (ws *WebSocket) validateFrame(fr *frames.Frame, fromClient bool) error {
 	if fr == nil {
 		ws.status = frames.ProtocolError
 		return errors.New("frame is nil")
 	}
 
-	if !fr.Masked {
+	if fromClient && !fr.Masked {
 		ws.status = frames.ProtocolError
 		return errors.New("protocol error: unmasked client frame")
 	}
 
+	if !fromClient && fr.Masked {
+		ws.status = frames.ProtocolError
+		return errors.New("protocol error: masked server frame")
+	}
+
 	if fr.IsControl() && (fr.PayloadLength > frames.PayloadLen125OrLess || !fr.Fin) {
 		ws.status = frames.ProtocolError
 		return errors.New("protocol error: control frames must have payload length <= 125 bytes and must not be fragmented")
@@ -199,9 +505,9 @@ func (ws *WebSocket) ValidateClientFrame(fr *frames.Frame) error {
 		return fmt.Errorf("protocol error: opcode %x is reserved or invalid", fr.OpCode)
 	}
 
-	if fr.Rsv1 || fr.Rsv2 || fr.Rsv3 {
+	if frameReservedBits(fr)&^ws.extensions.reservedBits() != 0 {
 		ws.status = frames.ProtocolError
-		return errors.New("protocol error: RSV bits must be 0")
+		return errors.New("protocol error: RSV bits must be 0 unless claimed by a negotiated extension")
 	}
 
 	if fr.OpCode == frames.OpClose {
@@ -224,168 +530,262 @@ func (ws *WebSocket) ValidateClientFrame(fr *frames.Frame) error {
 	return nil
 }
 
-// Close closes the connection gracefully
+// Close closes the connection gracefully with frames.NormalClosure
 func (ws *WebSocket) Close() error {
-	err := ws.WriteCloseMessage(ws.status, "")
-	if err != nil {
-		_ = ws.Conn.Close()
-		return err
-	}
-	return ws.Conn.Close()
+	return ws.CloseWithCode(frames.NormalClosure, "")
 }
 
-// CloseWithCode closes the connection with a specific status code and reason
+// CloseWithCode closes the connection with a specific status code and reason. It sends a close frame, waits up to the
+// close timeout for the peer's close frame and closes the underlying connection. If a close frame was already sent,
+// no other close frame is sent but the closing handshake is still awaited. While a message is being read, the peer's
+// close frame is left to the reads of the application, otherwise data messages are discarded until it arrives. It
+// does nothing once the underlying connection is closed.
 func (ws *WebSocket) CloseWithCode(statusCode frames.WebSocketStatusCode, reason string) error {
-	ws.status = statusCode
 	err := ws.WriteCloseMessage(statusCode, reason)
-	if err != nil {
-		ws.Conn.Close()
+	if errors.Is(err, ErrConnectionClosing) && ws.closedErr() != nil {
+		return nil
+	}
+	if err != nil && !errors.Is(err, ErrConnectionClosing) {
+		_ = ws.closeConn()
 		return err
 	}
-	return ws.Conn.Close()
+	ws.awaitClose()
+	return ws.closeConn()
 }
 
-// Read implements io.Reader
+// Read implements io.Reader over the payload of incoming data messages. Messages are streamed with NextReader as the
+// reader drains them, so message boundaries are not preserved. Read returns io.EOF once the connection was closed by
+// a closing handshake. A read deadline which expires leaves the connection usable as described for NextReader, so Read
+// continues where it stopped once the deadline is extended.
 func (ws *WebSocket) Read(p []byte) (int, error) {
-	return ws.Conn.Read(p)
+	ws.readMu.Lock()
+	defer ws.readMu.Unlock()
+	for {
+		if ws.readErr != nil {
+			return 0, ws.readErr
+		}
+		if len(p) == 0 {
+			return 0, nil
+		}
+		if ws.reader != nil && ws.reader.err == nil {
+			n, err := ws.reader.read(p)
+			if err == io.EOF {
+				if n == 0 {
+					continue
+				}
+				err = nil
+			}
+			if err != nil && !ws.retryable(err) {
+				ws.readErr = err
+			}
+			return n, err
+		}
+		_, _, err := ws.nextReader()
+		var closeErr *CloseError
+		switch {
+		case ws.retryable(err):
+			return 0, err
+		case errors.As(err, &closeErr):
+			ws.readErr = io.EOF
+		case err != nil:
+			ws.readErr = err
+		}
+	}
+}
+
+// SetWriteMessageType sets the type of the messages sent by Write, frames.OpBinary (the default) or frames.OpText.
+func (ws *WebSocket) SetWriteMessageType(messageType frames.Opcode) error {
+	if messageType != frames.OpText && messageType != frames.OpBinary {
+		return fmt.Errorf("invalid message type %v", messageType)
+	}
+	ws.writeOpcode = messageType
+	return nil
 }
 
-// Write implements io.Writer
+// Write implements io.Writer by sending p as a single data message of the type set by SetWriteMessageType. With text
+// messages every call must write complete UTF-8 sequences.
 func (ws *WebSocket) Write(p []byte) (int, error) {
-	n, err := ws.buff.Write(p)
+	var err error
+	if ws.writeOpcode == frames.OpText {
+		err = ws.WriteTextMessage(string(p))
+	} else {
+		err = ws.WriteBinaryMessage(p)
+	}
 	if err != nil {
-		return n, err
+		return 0, err
 	}
-	err = ws.buff.Flush()
-	return n, err
+	return len(p), nil
 }
 
-// ReadMessage reads a complete message, handling control frames and errors
+// ReadMessage reads a complete message, handling control frames and errors. Once the connection was closed by a
+// closing handshake it returns a *CloseError.
 func (ws *WebSocket) ReadMessage() (messageType frames.Opcode, data []byte, err error) {
-	defer ws.buff.Flush()
-	var payload []byte
-	var firstOpCode frames.Opcode
-	var inFragmentedMessage bool
+	messageType, r, err := ws.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err = io.ReadAll(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return messageType, data, nil
+}
 
+// NextReader returns the type of the next data message and a reader which streams its payload as the frames arrive.
+// Control frames are handled while the message is read. A message which was not read to the end is discarded when
+// NextReader is called again. Once the connection was closed by a closing handshake, NextReader returns a *CloseError,
+// and once it failed the error which failed it. A read deadline which expires before the next message starts is
+// returned without failing the connection, and so is one which expires in the payload of an uncompressed message:
+// once the deadline is extended the message reader continues where it stopped. A read deadline which expires in the
+// middle of a frame header or of a compressed message fails the connection.
+func (ws *WebSocket) NextReader() (frames.Opcode, io.Reader, error) {
+	ws.readMu.Lock()
+	defer ws.readMu.Unlock()
+	messageType, r, err := ws.nextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+	return messageType, r, nil
+}
+
+// nextReader implements NextReader for the goroutine which holds the read lock.
+func (ws *WebSocket) nextReader() (frames.Opcode, *messageReader, error) {
+	if prev := ws.reader; prev != nil {
+		if _, err := io.Copy(io.Discard, readerFunc(prev.read)); err != nil {
+			return 0, nil, err
+		}
+		ws.reader = nil
+		prev.err = errStaleReader
+	}
+	if err := ws.closedErr(); err != nil {
+		return 0, nil, err
+	}
+	frame, err := ws.nextFrame(false, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	raw := &frameReader{ws: ws}
+	raw.start(frame)
+	r := &messageReader{ws: ws, raw: raw, r: raw, text: frame.OpCode == frames.OpText}
+	if len(ws.extensions) > 0 {
+		decoded, err := ws.extensions.reader(frame.OpCode, frameReservedBits(frame), raw)
+		if err != nil {
+			return 0, nil, ws.fail(frames.ProtocolError, "extension failed to decode message", err)
+		}
+		r.r = decoded
+		r.decoded = true
+	}
+	ws.reader = r
+	return frame.OpCode, r, nil
+}
+
+// nextFrame reads frames until the next data frame, whose payload is left unread, handling the control frames on the
+// way. Within a message the data frame must be a continuation of the buffered bytes of payload, otherwise it must
+// start a new message. Once a close frame is received it returns the *CloseError of the closing handshake. The
+// returned frame is reused by the next call.
+func (ws *WebSocket) nextFrame(inMessage bool, buffered uint64) (*frames.Frame, error) {
 	for {
-		frame, err := ws.ReadFrame()
+		// A deadline which expires before the next frame starts leaves the connection usable.
+		if _, err := ws.buff.Peek(1); isTimeout(err) {
+			return nil, err
+		}
+		frame := &ws.inFrame
+		err := ws.readFrameHeader(frame, buffered)
+		var sizeErr *SizeLimitError
+		if errors.As(err, &sizeErr) {
+			return nil, ws.fail(frames.MessageTooBig, sizeErr.Error(), err)
+		}
 		if err != nil {
-			ws.status = frames.ProtocolError
-			closeErr := ws.WriteCloseMessage(frames.ProtocolError, "error reading frame")
-			if closeErr != nil {
-				_ = ws.Conn.Close()
-				return 0, nil, closeErr
+			return nil, ws.failRead(err)
+		}
+		if frame.IsControl() && frame.PayloadLength <= frames.PayloadLen125OrLess {
+			if err = ws.decoder.ReadPayload(frame, ws.controlPayload[:0]); err != nil {
+				return nil, ws.failRead(err)
 			}
-			_ = ws.Conn.Close()
-			return 0, nil, err
 		}
 
-		if err := ws.ValidateClientFrame(frame); err != nil {
-			closeErr := ws.WriteCloseMessage(ws.status, err.Error())
-			if closeErr != nil {
-				_ = ws.Conn.Close()
-				return 0, nil, closeErr
-			}
-			_ = ws.Conn.Close()
-			return 0, nil, err
+		if err := ws.validateFrame(frame, ws.isServer); err != nil {
+			return nil, ws.fail(ws.status, err.Error(), err)
 		}
 
-		if frame.IsControl() {
-			switch frame.OpCode {
-			case frames.OpClose:
-				code, reason, _ := frame.ReadCloseFrame()
-				if code == 0 {
-					code = frames.NormalClosure
-				}
-				closeErr := ws.WriteCloseMessage(code, reason)
-				if closeErr != nil {
-					_ = ws.Conn.Close()
-					return 0, nil, closeErr
-				}
-				_ = ws.Conn.Close()
-				return frames.OpClose, nil, nil
-			case frames.OpPing:
-				pongErr := ws.WritePongMessage(frame)
-				if pongErr != nil {
-					ws.status = frames.ProtocolError
-					closeErr := ws.WriteCloseMessage(frames.ProtocolError, "error sending pong")
-					if closeErr != nil {
-						_ = ws.Conn.Close()
-						return 0, nil, closeErr
-					}
-					_ = ws.Conn.Close()
-					return 0, nil, pongErr
-				}
-				continue
-			case frames.OpPong:
-				continue
-			}
+		if err := ws.extensions.readFrame(frame); err != nil {
+			return nil, ws.fail(frames.ProtocolError, err.Error(), err)
 		}
 
-		if frame.OpCode == frames.OpContinuation {
-			if !inFragmentedMessage {
-				ws.status = frames.ProtocolError
-				closeErr := ws.WriteCloseMessage(frames.ProtocolError, "continuation frame without preceding data frame")
-				if closeErr != nil {
-					_ = ws.Conn.Close()
-					return 0, nil, closeErr
-				}
-				_ = ws.Conn.Close()
-				return 0, nil, fmt.Errorf("protocol error: continuation frame without preceding data frame")
+		switch frame.OpCode {
+		case frames.OpClose:
+			code, reason, _ := frame.ReadCloseFrame()
+			if code == 0 {
+				code = frames.NormalClosure
 			}
-			payload = append(payload, frame.PayloadData...)
-		} else {
-			if inFragmentedMessage {
-				ws.status = frames.ProtocolError
-				closeErr := ws.WriteCloseMessage(frames.ProtocolError, "new data frame received while in fragmented message")
-				if closeErr != nil {
-					_ = ws.Conn.Close()
-					return 0, nil, closeErr
-				}
-				_ = ws.Conn.Close()
-				return 0, nil, fmt.Errorf("protocol error: new data frame received while in fragmented message")
+			var handlerErr error
+			if ws.receiveClose(code, reason) {
+				handlerErr = ws.CloseHandler()(code, reason)
 			}
-			if frame.OpCode != frames.OpText && frame.OpCode != frames.OpBinary {
-				ws.status = frames.ProtocolError
-				closeErr := ws.WriteCloseMessage(frames.ProtocolError, fmt.Sprintf("invalid data frame opcode %v", frame.OpCode))
-				if closeErr != nil {
-					_ = ws.Conn.Close()
-					return 0, nil, closeErr
-				}
-				_ = ws.Conn.Close()
-				return 0, nil, fmt.Errorf("protocol error: invalid data frame opcode %v", frame.OpCode)
+			_ = ws.closeConn()
+			if handlerErr != nil {
+				return nil, handlerErr
 			}
-			firstOpCode = frame.OpCode
-			payload = frame.PayloadData
-			inFragmentedMessage = !frame.Fin
-		}
-
-		if frame.Fin {
-			if firstOpCode == 0 {
-				ws.status = frames.ProtocolError
-				closeErr := ws.WriteCloseMessage(frames.ProtocolError, "no initial data frame for continuation")
-				if closeErr != nil {
-					_ = ws.Conn.Close()
-					return 0, nil, closeErr
-				}
-				_ = ws.Conn.Close()
-				return 0, nil, fmt.Errorf("protocol error: no initial data frame for continuation")
+			return nil, ws.closedErr()
+		case frames.OpPing:
+			if pingErr := ws.PingHandler()(controlData(frame)); pingErr != nil {
+				return nil, ws.fail(frames.ProtocolError, "error handling ping", pingErr)
 			}
-			if firstOpCode == frames.OpText && !utf8.Valid(payload) {
-				ws.status = frames.GotInconsistentData
-				closeErr := ws.WriteCloseMessage(frames.GotInconsistentData, "invalid UTF-8 in text message")
-				if closeErr != nil {
-					_ = ws.Conn.Close()
-					return 0, nil, closeErr
-				}
-				_ = ws.Conn.Close()
-				return 0, nil, fmt.Errorf("protocol error: invalid UTF-8 in complete text message")
+		case frames.OpPong:
+			ws.handlePong(frame.PayloadData)
+			if pongErr := ws.PongHandler()(controlData(frame)); pongErr != nil {
+				return nil, ws.fail(frames.ProtocolError, "error handling pong", pongErr)
 			}
-			return firstOpCode, payload, nil
+		case frames.OpContinuation:
+			if !inMessage {
+				return nil, ws.fail(frames.ProtocolError, "continuation frame without preceding data frame",
+					errors.New("protocol error: continuation frame without preceding data frame"))
+			}
+			return frame, nil
+		default:
+			if inMessage {
+				return nil, ws.fail(frames.ProtocolError, "new data frame received while in fragmented message",
+					errors.New("protocol error: new data frame received while in fragmented message"))
+			}
+			return frame, nil
 		}
 	}
 }
 
+// fail sends a close frame with the given status code and reason, unless one was already sent or received, closes the
+// connection and returns err. If the connection already ended, the reason it ended is returned instead.
+func (ws *WebSocket) fail(code frames.WebSocketStatusCode, reason string, err error) error {
+	ws.closeMu.Lock()
+	open := ws.state == stateOpen
+	if open {
+		ws.closeErr = err
+	} else if ws.closeErr != nil {
+		err = ws.closeErr
+	}
+	ws.closeMu.Unlock()
+	if open {
+		_ = ws.WriteCloseMessage(code, reason)
+	}
+	_ = ws.closeConn()
+	return err
+}
+
+// retryable returns true if err is a read timeout which left the connection usable, so the read can be retried once
+// the read deadline is extended.
+func (ws *WebSocket) retryable(err error) bool {
+	return isTimeout(err) && ws.closedErr() == nil
+}
+
+// failRead fails the connection after a frame could not be read. A read which timed out, e.g. because the context of
+// ReadMessageContext is done, is not the peer's fault, so the connection is closed with frames.GoingAway instead of
+// frames.ProtocolError.
+func (ws *WebSocket) failRead(err error) error {
+	if isTimeout(err) {
+		return ws.fail(frames.GoingAway, "read timed out", err)
+	}
+	return ws.fail(frames.ProtocolError, "error reading frame", err)
+}
+
 // ReadTextMessage reads a complete text message
 func (ws *WebSocket) ReadTextMessage() (string, error) {
 	messageType, data, err := ws.ReadMessage()
@@ -0,0 +1,322 @@
+package websock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testKey is the Sec-WebSocket-Key of the handshakes sent by upgradeRaw.
+const testKey string = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// serve starts an HTTP server which upgrades every request with u and runs handler with the connection, which is
+// closed once handler returns. It returns the ws:// URL of the server. Handlers still running when the test ends are
+// waited for, after the connections opened by dial are closed.
+func serve(t *testing.T, u *Upgrader, handler func(ws *WebSocket, r *http.Request)) string {
+	t.Helper()
+	var wg sync.WaitGroup
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wg.Add(1)
+		defer wg.Done()
+		ws, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = ws.closeConn() }()
+		handler(ws, r)
+	}))
+	t.Cleanup(func() {
+		srv.Close()
+		wg.Wait()
+	})
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// dial connects to the server at url with d. The connection is closed when the test ends.
+func dial(t *testing.T, d *Dialer, url string) *WebSocket {
+	t.Helper()
+	ws, _, err := d.Dial(context.Background(), url, nil)
+	if err != nil {
+		t.Fatalf("Dial(%q) error = %v", url, err)
+	}
+	t.Cleanup(func() { _ = ws.closeConn() })
+	return ws
+}
+
+// upgradeRaw sends an upgrade request with the extra header lines to the server at url on a plain TCP connection and
+// reads the response, so the test can speak the wire protocol itself. The connection is closed when the test ends.
+func upgradeRaw(t *testing.T, url string, header http.Header) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	addr := strings.TrimPrefix(url, "ws://")
+	if i := strings.IndexByte(addr, '/'); i >= 0 {
+		addr = addr[:i]
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	req, err := http.NewRequest(http.MethodGet, "http"+strings.TrimPrefix(url, "ws"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", testKey)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err = req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, resp
+}
+
+// upgradeRequest returns a valid upgrade request for a server which is not started.
+func upgradeRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Sec-WebSocket-Key", testKey)
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	return r
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	for _, compression := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain", true: "deflate"}[compression], func(t *testing.T) {
+			var opts *CompressionOptions
+			if compression {
+				opts = &CompressionOptions{}
+			}
+			type result struct {
+				messages, corrupted int
+				err                 error
+			}
+			results := make(chan result, 1)
+			url := serve(t, &Upgrader{Compression: opts}, func(ws *WebSocket, r *http.Request) {
+				var res result
+				for {
+					_, data, err := ws.ReadMessage()
+					if err != nil {
+						res.err = err
+						results <- res
+						return
+					}
+					res.messages++
+					// Every message repeats a single byte, so interleaved frames show up as mixed bytes.
+					if len(data) == 0 || !bytes.Equal(data, bytes.Repeat(data[:1], len(data))) {
+						res.corrupted++
+					}
+				}
+			})
+			ws := dial(t, &Dialer{Compression: opts}, url)
+			go func() {
+				for {
+					if _, _, err := ws.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+
+			const writers, messages = 8, 40
+			var wg sync.WaitGroup
+			for g := range writers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					data := bytes.Repeat([]byte{byte('a' + g)}, 20000)
+					for i := range messages {
+						var err error
+						switch i % 4 {
+						case 0:
+							err = ws.WriteFragmentedMessage(data, 1000, frames.OpBinary)
+						case 1:
+							var w io.WriteCloser
+							if w, err = ws.NextWriter(frames.OpText); err == nil {
+								for j := 0; j < len(data); j += 1000 {
+									_, _ = w.Write(data[j : j+1000])
+								}
+								err = w.Close()
+							}
+						case 2:
+							err = ws.WriteTextMessage(string(data[:100]))
+						case 3:
+							if err = ws.WritePingMessage("ping"); err == nil {
+								err = ws.WriteBinaryMessage(data)
+							}
+						}
+						if err != nil {
+							t.Errorf("write error = %v", err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			if err := ws.WriteCloseMessage(frames.NormalClosure, ""); err != nil {
+				t.Fatal(err)
+			}
+			res := <-results
+			var closeErr *CloseError
+			if !errors.As(res.err, &closeErr) || closeErr.Code != frames.NormalClosure {
+				t.Errorf("server ReadMessage() error = %v, want a normal closure", res.err)
+			}
+			if res.messages != writers*messages || res.corrupted != 0 {
+				t.Errorf("server received %d messages with %d corrupted, want %d intact", res.messages, res.corrupted, writers*messages)
+			}
+		})
+	}
+}
+
+func TestWriteFramesRoundTrip(t *testing.T) {
+	large := bytes.Repeat([]byte("abcdefg"), 300000)
+	want := bytes.Clone(large)
+	received := make(chan [][]byte, 1)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		// The server sends the large payloads with vectored writes, the client masks them through its write buffer.
+		_ = ws.WriteBinaryMessage(large)
+		_ = ws.WriteFragmentedMessage(large, 100000, frames.OpBinary)
+		_ = ws.WriteTextMessage("small")
+		var messages [][]byte
+		for range 2 {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				break
+			}
+			messages = append(messages, data)
+		}
+		received <- messages
+	})
+	ws := dial(t, &Dialer{}, url)
+	for i := range 3 {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("message %d: ReadMessage() error = %v", i, err)
+		}
+		if i == 2 && string(data) != "small" || i != 2 && !bytes.Equal(data, want) {
+			t.Fatalf("message %d differs, got %d bytes", i, len(data))
+		}
+	}
+	if err := ws.WriteBinaryMessage(large); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteFragmentedMessage(large, 100000, frames.OpBinary); err != nil {
+		t.Fatal(err)
+	}
+	messages := <-received
+	if len(messages) != 2 || !bytes.Equal(messages[0], want) || !bytes.Equal(messages[1], want) {
+		t.Errorf("server received %d intact messages, want 2", len(messages))
+	}
+	if !bytes.Equal(large, want) {
+		t.Error("writes modified the payload of the caller")
+	}
+}
+
+func TestWriteFramesAllocations(t *testing.T) {
+	for _, isServer := range []bool{true, false} {
+		conn, peer := net.Pipe()
+		go func() {
+			_, _ = io.Copy(io.Discard, peer)
+		}()
+		buff := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+		ws := &WebSocket{Conn: conn, buff: buff, isServer: isServer}
+		large := make([]byte, 1<<20)
+		fragments, err := frames.FragmentedFrames(large, 100000, frames.OpBinary, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = ws.WriteFrames(fragments)
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		for range 10 {
+			if err = ws.WriteFrames(fragments); err != nil {
+				t.Fatal(err)
+			}
+		}
+		runtime.ReadMemStats(&after)
+		// Payloads are neither copied nor masked into new allocations.
+		if allocated := (after.TotalAlloc - before.TotalAlloc) / 10; allocated > 4096 {
+			t.Errorf("server %v: WriteFrames() of %d bytes allocates %d bytes", isServer, len(large), allocated)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestWriteFramesLetsControlFramesThrough(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	buff := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	ws := &WebSocket{Conn: conn, buff: buff, isServer: true}
+	fragments, err := frames.FragmentedFrames(make([]byte, 16*maxBatchWrite), maxBatchWrite, frames.OpBinary, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// waitFrameLock waits until the frame lock is in the state reported by done.
+	waitFrameLock := func(done func(l *frameLock) bool) {
+		for {
+			ws.frameMu.mu.Lock()
+			ok := done(&ws.frameMu)
+			ws.frameMu.mu.Unlock()
+			if ok {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	written := make(chan error, 2)
+	go func() {
+		written <- ws.WriteFrames(fragments)
+	}()
+	// The pipe is unbuffered, so the sequence is stuck in its first write until the peer reads.
+	waitFrameLock(func(l *frameLock) bool { return l.busy })
+	go func() {
+		written <- ws.WritePingMessage("ping")
+	}()
+	waitFrameLock(func(l *frameLock) bool { return l.controlWaiting > 0 })
+	decoder := frames.NewDecoder(peer)
+	var frame frames.Frame
+	data := 0
+	for {
+		if err = decoder.Decode(&frame); err != nil {
+			t.Fatal(err)
+		}
+		if frame.OpCode == frames.OpPing {
+			break
+		}
+		if data++; frame.Fin {
+			t.Fatal("the ping was held back until the whole sequence was written")
+		}
+	}
+	if data != 1 {
+		t.Errorf("the ping was held back for %d frames, want 1", data)
+	}
+	for !frame.Fin || frame.OpCode == frames.OpPing {
+		if err = decoder.Decode(&frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range 2 {
+		if err = <-written; err != nil {
+			t.Errorf("write error = %v", err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package websock
+
+import (
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"sync"
+)
+
+// errWriterClosed is returned by a message writer once it was closed.
+var errWriterClosed = errors.New("write to a closed message writer")
+
+// messageWriter is the writer of an outgoing message returned by NextWriter.
+type messageWriter struct {
+	ws     *WebSocket
+	w      io.WriteCloser
+	closed bool
+}
+
+func (m *messageWriter) Write(p []byte) (int, error) {
+	if m.closed {
+		return 0, errWriterClosed
+	}
+	return m.w.Write(p)
+}
+
+// Close flushes the message, sends its final frame and lets other data messages be sent. Closing a closed writer does
+// nothing.
+func (m *messageWriter) Close() error {
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	defer m.ws.messageMu.Unlock()
+	return m.w.Close()
+}
+
+// frameWriter buffers the encoded payload of an outgoing message and sends it as a first data frame followed by
+// continuation frames, each carrying up to a full buffer. The final frame is sent when the writer is closed.
+type frameWriter struct {
+	ws     *WebSocket
+	opcode frames.Opcode
+	rsv    ReservedBits
+	buf    []byte
+	err    error
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	total := len(p)
+	for len(p) > 0 {
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(false); err != nil {
+				return total - len(p), err
+			}
+		}
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// Close sends the buffered payload as the final frame of the message.
+func (w *frameWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.flush(true); err != nil {
+		return err
+	}
+	w.err = errWriterClosed
+	return nil
+}
+
+// flush sends the buffered payload as the next frame of the message.
+func (w *frameWriter) flush(fin bool) error {
+	frame, err := frames.NewServerFrame(fin, w.opcode, w.buf)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	setFrameReservedBits(frame, w.rsv)
+	w.opcode = frames.OpContinuation
+	w.rsv = 0
+	w.buf = w.buf[:0]
+	if err = w.ws.writeFrames([]*frames.Frame{frame}); err != nil {
+		w.err = err
+	}
+	return err
+}
+
+// frameLock serializes the frames written to a connection. Control frames waiting for the lock are let through before
+// data frames, so they can go in between the frames of a long message without splitting one.
+type frameLock struct {
+	mu             sync.Mutex
+	cond           sync.Cond
+	busy           bool
+	controlWaiting int
+}
+
+// lock acquires the lock for writing a control or data frame.
+func (l *frameLock) lock(control bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cond.L == nil {
+		l.cond.L = &l.mu
+	}
+	if control {
+		l.controlWaiting++
+		defer func() { l.controlWaiting-- }()
+	}
+	for l.busy || !control && l.controlWaiting > 0 {
+		l.cond.Wait()
+	}
+	l.busy = true
+}
+
+// yield hands the lock to the control frames waiting for it, if any, and acquires it again.
+func (l *frameLock) yield(control bool) {
+	l.mu.Lock()
+	waiting := l.controlWaiting > 0
+	l.mu.Unlock()
+	if waiting {
+		l.unlock()
+		l.lock(control)
+	}
+}
+
+// unlock releases the lock.
+func (l *frameLock) unlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.busy = false
+	if l.cond.L != nil {
+		l.cond.Broadcast()
+	}
+}
@@ -0,0 +1,167 @@
+package websock
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"github.com/blazskufca/gowebsock/frames"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNextWriterFrames(t *testing.T) {
+	received := make(chan []*frames.Frame, 2)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		var message []*frames.Frame
+		for {
+			frame, err := ws.ReadFrame()
+			if err != nil {
+				return
+			}
+			message = append(message, frame)
+			if frame.Fin {
+				received <- message
+				message = nil
+			}
+		}
+	})
+	ws := dial(t, &Dialer{WriteBufferSize: 1024}, url)
+	data := make([]byte, 5000)
+	_, _ = rand.Read(data)
+	w, err := ws.NextWriter(frames.OpBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+	if _, err = w.Write([]byte("late")); !errors.Is(err, errWriterClosed) {
+		t.Errorf("Write() after Close() error = %v, want %v", err, errWriterClosed)
+	}
+
+	message := <-received
+	var payload []byte
+	for i, frame := range message {
+		wantOpcode := frames.OpContinuation
+		if i == 0 {
+			wantOpcode = frames.OpBinary
+		}
+		if frame.OpCode != wantOpcode || frame.Fin != (i == len(message)-1) || frame.PayloadLength > 1024 {
+			t.Errorf("frame %d = %v, fin %v, %d bytes", i, frame.OpCode, frame.Fin, frame.PayloadLength)
+		}
+		payload = append(payload, frame.PayloadData...)
+	}
+	if len(message) < 5 || !bytes.Equal(payload, data) {
+		t.Errorf("received %d frames with %d bytes, want the message in frames of the write buffer", len(message), len(payload))
+	}
+
+	if w, err = ws.NextWriter(frames.OpText); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if message = <-received; len(message) != 1 || message[0].OpCode != frames.OpText || message[0].PayloadLength != 0 {
+		t.Errorf("empty message = %v", message)
+	}
+}
+
+func TestNextWriterRoundTrip(t *testing.T) {
+	data := make([]byte, 100000)
+	_, _ = rand.Read(data)
+	for _, compression := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain", true: "deflate"}[compression], func(t *testing.T) {
+			var opts *CompressionOptions
+			if compression {
+				opts = &CompressionOptions{}
+			}
+			received := make(chan []byte, 5)
+			url := serve(t, &Upgrader{Compression: opts}, func(ws *WebSocket, r *http.Request) {
+				for {
+					_, data, err := ws.ReadMessage()
+					if err != nil {
+						return
+					}
+					received <- data
+				}
+			})
+			ws := dial(t, &Dialer{Compression: opts}, url)
+			for i := range 3 {
+				w, err := ws.NextWriter(frames.OpBinary)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err = io.Copy(w, bytes.NewReader(data)); err != nil {
+					t.Fatal(err)
+				}
+				if err = w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if got := <-received; !bytes.Equal(got, data) {
+					t.Errorf("message %d differs, got %d bytes", i, len(got))
+				}
+			}
+			for _, s := range []string{"first", "second"} {
+				w, err := ws.NextWriter(frames.OpText)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, _ = io.WriteString(w, s)
+				if err = w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if got := string(<-received); got != s {
+					t.Errorf("message = %q, want %q", got, s)
+				}
+			}
+		})
+	}
+}
+
+func TestNextWriterHoldsBackMessages(t *testing.T) {
+	received := make(chan string, 2)
+	url := serve(t, &Upgrader{}, func(ws *WebSocket, r *http.Request) {
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(data)
+		}
+	})
+	ws := dial(t, &Dialer{}, url)
+	w, err := ws.NextWriter(frames.OpText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- ws.WriteTextMessage("second")
+	}()
+	_, _ = io.WriteString(w, "first")
+	select {
+	case err = <-sent:
+		t.Fatalf("WriteTextMessage() returned %v while a writer was open", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-sent; err != nil {
+		t.Fatal(err)
+	}
+	if got := []string{<-received, <-received}; got[0] != "first" || got[1] != "second" {
+		t.Errorf("received %q, want [first second]", got)
+	}
+	if _, err = ws.NextWriter(frames.OpPing); err == nil {
+		t.Error("NextWriter(OpPing) error = nil")
+	}
+}